@@ -0,0 +1,105 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"sort"
+
+	"github.com/ahui2016/txt/model"
+	"github.com/ahui2016/txt/mydb"
+	"github.com/gin-gonic/gin"
+)
+
+// 每次最多读取多少条修改记录
+const changesLimit = 500
+
+// ChangeFeed 是 "/cli/changes" 的返回结果，已把多条修改记录合并为最终结果，
+// 客户端按顺序处理 Deleted, Created, Updated 即可更新本地缓存。
+// 注意：其中 TxtMsg.Index 是修改当时的流水号，不一定是最新的。
+type ChangeFeed struct {
+	Created []model.TxtMsg // 新增的消息 (包括转换后的消息)
+	Updated []model.TxtMsg // 内容或别名被修改的消息
+	Deleted []string       // 被删除的消息的 ID (包括转换前的 ID)
+	Last    uint64         // 下次请求时使用的 since
+	More    bool           // 是否还有更多修改记录
+}
+
+// checkChangesErr 与 checkErr 相同，但所需的修改记录已被删除时返回 410,
+// 客户端应重新获取全部数据 (since=0)。
+func checkChangesErr(c *gin.Context, err error) bool {
+	if errors.Is(err, mydb.ErrChangesTrimmed) {
+		c.JSON(http.StatusGone, APIError{Code: codeTrimmed, Message: err.Error()})
+		return true
+	}
+	return checkErr(c, err)
+}
+
+// mergeChanges 把按时间顺序排列的修改记录合并为 ChangeFeed.
+func mergeChanges(changes []model.Change) (feed ChangeFeed) {
+	created := make(map[string]model.TxtMsg)
+	updated := make(map[string]model.TxtMsg)
+	deleted := make(map[string]bool)
+
+	remove := func(id string) {
+		delete(created, id)
+		delete(updated, id)
+		deleted[id] = true
+	}
+	for _, ch := range changes {
+		switch ch.Op {
+		case model.OpInsert:
+			delete(deleted, ch.ID)
+			created[ch.ID] = ch.Msg
+		case model.OpToggle:
+			remove(ch.OldID)
+			delete(deleted, ch.ID)
+			created[ch.ID] = ch.Msg
+		case model.OpDelete:
+			remove(ch.ID)
+		default: // OpEdit, OpAlias
+			if _, ok := created[ch.ID]; ok {
+				created[ch.ID] = ch.Msg
+			} else {
+				delete(deleted, ch.ID)
+				updated[ch.ID] = ch.Msg
+			}
+		}
+	}
+
+	for _, tm := range created {
+		feed.Created = append(feed.Created, tm)
+	}
+	for _, tm := range updated {
+		feed.Updated = append(feed.Updated, tm)
+	}
+	for id := range deleted {
+		feed.Deleted = append(feed.Deleted, id)
+	}
+	sort.Slice(feed.Created, func(i, j int) bool { return feed.Created[i].ID < feed.Created[j].ID })
+	sort.Slice(feed.Updated, func(i, j int) bool { return feed.Updated[i].ID < feed.Updated[j].ID })
+	sort.Strings(feed.Deleted)
+	return
+}
+
+// cliChangesHandler 返回流水号大于 since 的全部修改，
+// 客户端可据此维护本地缓存，无需反复获取整个列表。
+func cliChangesHandler(c *gin.Context) {
+	type form struct {
+		Since uint64 `form:"since"`
+	}
+	var f form
+	if BindCheck(c, &f) {
+		return
+	}
+	changes, err := db.ChangesSince(f.Since, changesLimit)
+	if checkChangesErr(c, err) {
+		return
+	}
+	feed := mergeChanges(changes)
+	feed.Last = f.Since
+	if n := len(changes); n > 0 {
+		feed.Last = changes[n-1].Seq
+	}
+	feed.More = len(changes) == changesLimit
+	c.JSON(OK, feed)
+}
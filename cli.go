@@ -0,0 +1,429 @@
+package main
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"github.com/ahui2016/txt/client"
+	"github.com/ahui2016/txt/model"
+)
+
+// 客户端配置文件，保存在 appConfigFolder 里。
+const cliConfigFileName = "cli-config.json"
+
+const cliUsage = `Usage: txt [command] [arguments]
+
+Without a command, txt starts the server (see "txt -h" for server options).
+
+Commands:
+  login -server URL -key KEY     save the server URL and the secret key
+        [-fingerprint FP]        trust the server certificate with this SHA-256 fingerprint
+  send [message]                 send a message (read from stdin if omitted)
+  get [-wait] [alias|index]      get a message and copy it to the clipboard
+  list [-n 5] [-p] [-start 1]    list recent messages (-p: permanent messages)
+  search [-t|-p] keyword         search messages
+  toggle alias|index             move a message between temporary and permanent
+  delete alias|index             delete a message
+  alias                          list all aliases
+  alias index|alias new-alias    set the alias of a message
+  alias -d index|alias           remove the alias of a message
+  qr [-share] [alias|index]      show a message (or a new share link) as a QR code
+  config print [server options]  show the server settings and where each one comes from
+  init [-password PWD] [server options]
+                                 set the initial password of a new database (the server must be stopped)
+  help                           show this help
+`
+
+// clientCommands 是全部客户端子命令。
+var clientCommands = map[string]func(args []string) error{
+	"login":  cliLogin,
+	"send":   cliSend,
+	"get":    cliGet,
+	"list":   cliList,
+	"search": cliSearch,
+	"toggle": cliToggle,
+	"delete": cliDelete,
+	"alias":  cliAlias,
+	"qr":     cliQR,
+	"config": cliConfigCmd,
+	"init":   cliInit,
+	"help":   cliHelp,
+}
+
+func isClientCommand(name string) bool {
+	_, ok := clientCommands[name]
+	return ok
+}
+
+// runClient 执行客户端子命令，返回 exit code.
+func runClient(name string, args []string) int {
+	if err := clientCommands[name](args); err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		return 1
+	}
+	return 0
+}
+
+type cliConfig struct {
+	Server      string
+	Key         string
+	Fingerprint string `json:",omitempty"` // 服务器证书的指纹 (自签名证书)
+}
+
+func cliConfigPath() string {
+	return filepath.Join(appConfigFolder(), cliConfigFileName)
+}
+
+func loadCliConfig() (cfg cliConfig, err error) {
+	data, err := os.ReadFile(cliConfigPath())
+	if errors.Is(err, os.ErrNotExist) {
+		return cfg, fmt.Errorf("not logged in, please run: txt login -server URL -key KEY")
+	}
+	if err != nil {
+		return
+	}
+	err = json.Unmarshal(data, &cfg)
+	return
+}
+
+func (cfg cliConfig) save() error {
+	data, err := json.MarshalIndent(cfg, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(cliConfigPath(), data, 0600)
+}
+
+// client 返回连接到服务器的客户端。
+func (cfg cliConfig) client() *client.Client {
+	if cfg.Fingerprint != "" {
+		return client.NewPinned(cfg.Server, cfg.Key, cfg.Fingerprint)
+	}
+	return client.New(cfg.Server, cfg.Key)
+}
+
+func cliHelp(_ []string) error {
+	fmt.Print(cliUsage)
+	return nil
+}
+
+func cliLogin(args []string) error {
+	fs := flag.NewFlagSet("login", flag.ExitOnError)
+	server := fs.String("server", "", "the server URL, for example https://example.com")
+	key := fs.String("key", "", "the secret key")
+	fingerprint := fs.String("fingerprint", "", "the SHA-256 fingerprint of a self-signed server certificate")
+	_ = fs.Parse(args)
+	if *server == "" || *key == "" {
+		return fmt.Errorf("usage: txt login -server URL -key KEY [-fingerprint FP]")
+	}
+	cfg := cliConfig{Server: *server, Key: *key, Fingerprint: *fingerprint}
+	// 确认网址与密钥正确后才保存。
+	if _, err := cfg.client().Aliases(context.Background()); err != nil {
+		var certErr x509.UnknownAuthorityError
+		if errors.As(err, &certErr) {
+			// 自签名证书，显示指纹供用户与服务器启动时打印的指纹对比。
+			if fp, err := client.ServerFingerprint(*server); err == nil {
+				fmt.Fprintln(os.Stderr, "The server certificate fingerprint (SHA-256) is:\n  "+fp)
+				fmt.Fprintln(os.Stderr, "If it matches the one printed by the server, run login again with -fingerprint.")
+			}
+		}
+		return err
+	}
+	if err := cfg.save(); err != nil {
+		return err
+	}
+	fmt.Println("Saved to", cliConfigPath())
+	return nil
+}
+
+func cliSend(args []string) error {
+	fs := flag.NewFlagSet("send", flag.ExitOnError)
+	device := fs.String("device", "", "the name of this device (optional)")
+	_ = fs.Parse(args)
+	cfg, err := loadCliConfig()
+	if err != nil {
+		return err
+	}
+	msg := strings.Join(fs.Args(), " ")
+	if msg == "" {
+		data, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			return err
+		}
+		msg = strings.TrimRight(string(data), "\r\n")
+	}
+	if msg == "" {
+		return fmt.Errorf("the message is empty")
+	}
+	return cfg.client().AddFrom(context.Background(), msg, *device)
+}
+
+func cliGet(args []string) error {
+	fs := flag.NewFlagSet("get", flag.ExitOnError)
+	wait := fs.Bool("wait", false, "wait for the next new message")
+	timeout := fs.Int("timeout", 0, "with -wait, give up after this many seconds (0 means wait forever)")
+	noCopy := fs.Bool("no-copy", false, "do not copy to the clipboard")
+	_ = fs.Parse(args)
+	cfg, err := loadCliConfig()
+	if err != nil {
+		return err
+	}
+
+	var tm model.TxtMsg
+	if *wait {
+		tm, err = cliWait(cfg.client(), *timeout)
+	} else {
+		a_or_i := "t1"
+		if fs.NArg() > 0 {
+			a_or_i = fs.Arg(0)
+		}
+		tm, err = cfg.client().Get(context.Background(), a_or_i)
+	}
+	if err != nil {
+		return err
+	}
+	// 只打印消息内容，以便用于管道，例如 bash <(txt get cmd)
+	fmt.Println(tm.Msg)
+	if !*noCopy {
+		_ = copyToClipboard(tm.Msg) // 找不到剪贴板工具时忽略
+	}
+	return nil
+}
+
+// cliWait 等待下一条新的暂存消息, timeout 为零表示一直等待。
+func cliWait(c *client.Client, timeout int) (tm model.TxtMsg, err error) {
+	ctx := context.Background()
+	// 先取得当前最新的消息，以免在多次请求之间遗漏新消息。
+	items, err := c.List(ctx, client.TempBucket, 1, 1)
+	if err != nil {
+		return
+	}
+	after := ""
+	if len(items) > 0 {
+		after = items[0].ID
+	}
+	for {
+		tm, err = c.Wait(ctx, after, timeout)
+		if err == client.ErrTimeout && timeout <= 0 {
+			continue
+		}
+		return
+	}
+}
+
+// printItems 把消息列表打印到 w, 每条消息的第一行是流水号与别名。
+func printItems(w io.Writer, items []model.TxtMsg) {
+	for _, tm := range items {
+		prefix := "T"
+		if tm.Cat == model.CatPerm {
+			prefix = "P"
+		}
+		header := prefix + strconv.Itoa(tm.Index)
+		if tm.Alias != "" {
+			header += " [" + tm.Alias + "]"
+		}
+		fmt.Fprintf(w, "%s\n%s\n\n", header, tm.Msg)
+	}
+}
+
+func cliList(args []string) error {
+	fs := flag.NewFlagSet("list", flag.ExitOnError)
+	n := fs.Int("n", 5, "how many messages to list")
+	perm := fs.Bool("p", false, "list permanent messages")
+	start := fs.Int("start", 1, "start from this index")
+	_ = fs.Parse(args)
+	cfg, err := loadCliConfig()
+	if err != nil {
+		return err
+	}
+	bucket := client.TempBucket
+	if *perm {
+		bucket = client.PermBucket
+	}
+	items, err := cfg.client().List(context.Background(), bucket, *start, *n)
+	if err != nil {
+		return err
+	}
+	printItems(os.Stdout, items)
+	return nil
+}
+
+func cliSearch(args []string) error {
+	fs := flag.NewFlagSet("search", flag.ExitOnError)
+	temp := fs.Bool("t", false, "search temporary messages only")
+	perm := fs.Bool("p", false, "search permanent messages only")
+	_ = fs.Parse(args)
+	if fs.NArg() == 0 {
+		return fmt.Errorf("usage: txt search [-t|-p] keyword")
+	}
+	cfg, err := loadCliConfig()
+	if err != nil {
+		return err
+	}
+	var buckets []string
+	if *temp {
+		buckets = append(buckets, client.TempBucket)
+	}
+	if *perm {
+		buckets = append(buckets, client.PermBucket)
+	}
+	keyword := strings.Join(fs.Args(), " ")
+	items, err := cfg.client().Search(context.Background(), keyword, buckets...)
+	if err != nil {
+		return err
+	}
+	printItems(os.Stdout, items)
+	return nil
+}
+
+func cliToggle(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: txt toggle alias|index")
+	}
+	cfg, err := loadCliConfig()
+	if err != nil {
+		return err
+	}
+	tm, err := cfg.client().Toggle(context.Background(), args[0])
+	if err != nil {
+		return err
+	}
+	printItems(os.Stdout, []model.TxtMsg{tm})
+	return nil
+}
+
+func cliDelete(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: txt delete alias|index")
+	}
+	cfg, err := loadCliConfig()
+	if err != nil {
+		return err
+	}
+	return cfg.client().Delete(context.Background(), args[0])
+}
+
+func cliAlias(args []string) error {
+	fs := flag.NewFlagSet("alias", flag.ExitOnError)
+	remove := fs.Bool("d", false, "remove the alias")
+	_ = fs.Parse(args)
+	cfg, err := loadCliConfig()
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	switch {
+	case fs.NArg() == 0 && !*remove:
+		aliases, err := cfg.client().Aliases(ctx)
+		if err != nil {
+			return err
+		}
+		for _, alias := range aliases {
+			fmt.Println(alias.ID)
+		}
+		return nil
+	case fs.NArg() == 1 && *remove:
+		return cfg.client().SetAlias(ctx, fs.Arg(0), "")
+	case fs.NArg() == 2 && !*remove:
+		return cfg.client().SetAlias(ctx, fs.Arg(0), fs.Arg(1))
+	}
+	return fmt.Errorf("usage: txt alias [index|alias new-alias] or txt alias -d index|alias")
+}
+
+// cliQR 在终端里显示二维码，使用 -share 时先生成分享链接，以便手机不需要密钥即可打开。
+func cliQR(args []string) error {
+	fs := flag.NewFlagSet("qr", flag.ExitOnError)
+	share := fs.Bool("share", false, "show a new share link instead of the message")
+	expires := fs.String("expires", "10m", "with -share, the link expires after this duration (empty means never)")
+	maxViews := fs.Int("max-views", 0, "with -share, how many times the link can be viewed (0 means unlimited)")
+	invert := fs.Bool("invert", false, "for terminals with a light background")
+	_ = fs.Parse(args)
+	cfg, err := loadCliConfig()
+	if err != nil {
+		return err
+	}
+	a_or_i := "t1"
+	if fs.NArg() > 0 {
+		a_or_i = fs.Arg(0)
+	}
+
+	ctx := context.Background()
+	c := cfg.client()
+	token := ""
+	if *share {
+		s, link, err := c.Share(ctx, a_or_i, *expires, *maxViews)
+		if err != nil {
+			return err
+		}
+		token = s.Token
+		defer fmt.Println(link)
+	}
+	data, err := c.QR(ctx, a_or_i, token, client.QROptions{Format: "txt", Invert: *invert})
+	if err != nil {
+		return err
+	}
+	fmt.Print(string(data))
+	return nil
+}
+
+// clipboardCommands 返回当前系统可能可用的剪贴板工具 (按优先顺序)。
+func clipboardCommands() (cmds [][]string) {
+	switch runtime.GOOS {
+	case "darwin":
+		return [][]string{{"pbcopy"}}
+	case "windows":
+		return [][]string{{"clip"}}
+	}
+	if os.Getenv("WAYLAND_DISPLAY") != "" {
+		cmds = append(cmds, []string{"wl-copy"})
+	}
+	if os.Getenv("DISPLAY") != "" {
+		cmds = append(cmds,
+			[]string{"xclip", "-selection", "clipboard"},
+			[]string{"xsel", "--clipboard", "--input"})
+	}
+	// WSL
+	return append(cmds, []string{"clip.exe"})
+}
+
+// copyToClipboard 使用系统中找到的第一个剪贴板工具复制 text.
+func copyToClipboard(text string) error {
+	for _, cmd := range clipboardCommands() {
+		if _, err := exec.LookPath(cmd[0]); err != nil {
+			continue
+		}
+		c := exec.Command(cmd[0], cmd[1:]...)
+		c.Stdin = strings.NewReader(text)
+		return c.Run()
+	}
+	return fmt.Errorf("clipboard tool not found")
+}
+
+// cliConfigCmd 不连接服务器，而是显示本机启动服务器时使用的设置
+// (命令行参数 > 环境变量 > 配置文件 > 默认值)。
+func cliConfigCmd(args []string) error {
+	if len(args) == 0 || args[0] != "print" {
+		return fmt.Errorf("usage: txt config print [server options]")
+	}
+	if err := flag.CommandLine.Parse(args[1:]); err != nil {
+		return err
+	}
+	info, err := applyConfig(flag.CommandLine)
+	if err != nil {
+		return err
+	}
+	printConfig(os.Stdout, flag.CommandLine, info)
+	return nil
+}
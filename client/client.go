@@ -0,0 +1,322 @@
+// Package client 是 txt 服务器 "/cli" 接口的 Go 客户端。
+//
+//	c := client.New("https://example.com", key)
+//	err := c.Add(ctx, "hello")
+//	tm, err := c.Get(ctx, "t1")
+//	if errors.Is(err, client.ErrNotFound) { ... }
+package client
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/ahui2016/txt/model"
+)
+
+// 与服务器返回的错误信息相对应的错误，可使用 errors.Is 判断。
+var (
+	ErrWrongKey   = errors.New("wrong key")
+	ErrKeyExpired = errors.New("the key is expired")
+	ErrNotFound   = errors.New("not found")
+	// ErrTimeout 表示 Wait 超时仍未出现新消息。
+	ErrTimeout = errors.New("timeout")
+	// ErrFingerprint 表示服务器证书的指纹与 NewPinned 指定的不一致。
+	ErrFingerprint = errors.New("the server certificate does not match the pinned fingerprint")
+)
+
+// 服务器返回的错误代码 (与 /v2 相同，不会随意改变)。
+const (
+	codeWrongKey   = "wrong_key"
+	codeKeyExpired = "key_expired"
+	codeNotFound   = "not_found"
+)
+
+// 与服务器的 mydb.TempBucket, mydb.PermBucket 相同。
+const (
+	TempBucket = "temporary-bucket"
+	PermBucket = "permanent-bucket"
+)
+
+// Error 是服务器返回的错误 (HTTP 状态码不是 200)。
+type Error struct {
+	StatusCode int
+	Code       string // 错误代码，例如 "not_found", 旧版本的服务器没有
+	Message    string
+}
+
+func (e *Error) Error() string {
+	if e.Message == "" {
+		return http.StatusText(e.StatusCode)
+	}
+	return e.Message
+}
+
+// Unwrap 根据错误代码 (没有错误代码时根据状态码) 使 errors.Is(err, ErrWrongKey) 等判断成立。
+func (e *Error) Unwrap() error {
+	switch e.Code {
+	case codeWrongKey:
+		return ErrWrongKey
+	case codeKeyExpired:
+		return ErrKeyExpired
+	case codeNotFound:
+		return ErrNotFound
+	}
+	switch e.StatusCode {
+	case http.StatusUnauthorized:
+		return ErrWrongKey
+	case http.StatusNotFound:
+		return ErrNotFound
+	}
+	return nil
+}
+
+// Client 的零值不可用，请使用 New.
+type Client struct {
+	Server string // 例如 https://example.com
+	Key    string
+	HTTP   *http.Client
+}
+
+// New 返回一个使用 http.DefaultClient 的 Client.
+func New(server, key string) *Client {
+	return &Client{
+		Server: strings.TrimSuffix(server, "/"),
+		Key:    key,
+		HTTP:   http.DefaultClient,
+	}
+}
+
+// send 向服务器发送请求，状态码不是 200 时返回 *Error (204 时返回 ErrTimeout)。
+// 密钥通过 Authorization 传递，不会出现在网址或表单中。调用者负责关闭 resp.Body.
+func (c *Client) send(ctx context.Context, method, path string, form url.Values) (*http.Response, error) {
+	if form == nil {
+		form = url.Values{}
+	}
+	target := c.Server + path
+
+	var req *http.Request
+	var err error
+	if method == http.MethodGet {
+		req, err = http.NewRequestWithContext(ctx, method, target+"?"+form.Encode(), nil)
+	} else {
+		req, err = http.NewRequestWithContext(ctx, method, target, strings.NewReader(form.Encode()))
+		if req != nil {
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.Key)
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode == http.StatusNoContent {
+		resp.Body.Close()
+		return nil, ErrTimeout
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		var body struct{ Code, Message string }
+		_ = json.NewDecoder(resp.Body).Decode(&body)
+		return nil, &Error{StatusCode: resp.StatusCode, Code: body.Code, Message: body.Message}
+	}
+	return resp, nil
+}
+
+// do 向服务器发送请求，并把返回的 JSON 解码到 result (可以为 nil)。
+func (c *Client) do(ctx context.Context, method, path string, form url.Values, result interface{}) error {
+	resp, err := c.send(ctx, method, path, form)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if result == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(result); err != nil {
+		return fmt.Errorf("decode response of %s: %w", path, err)
+	}
+	return nil
+}
+
+// Add 新增一条暂存消息。
+func (c *Client) Add(ctx context.Context, msg string) error {
+	return c.AddFrom(ctx, msg, "")
+}
+
+// AddFrom 新增一条暂存消息，并记录发送消息的设备 (该设备必须已登记)。
+func (c *Client) AddFrom(ctx context.Context, msg, device string) error {
+	form := url.Values{"msg": {msg}}
+	if device != "" {
+		form.Set("device", device)
+	}
+	return c.do(ctx, http.MethodPost, "/cli/add", form, nil)
+}
+
+// Get 根据别名或流水号 (比如 "t1", "p2") 获取一条消息。
+func (c *Client) Get(ctx context.Context, aliasOrIndex string) (tm model.TxtMsg, err error) {
+	err = c.do(ctx, http.MethodPost, "/cli/get-by-a-or-i",
+		url.Values{"a_or_i": {aliasOrIndex}}, &tm)
+	return
+}
+
+// Toggle 在暂存消息与永久消息之间转换，返回转换后的消息。
+func (c *Client) Toggle(ctx context.Context, aliasOrIndex string) (tm model.TxtMsg, err error) {
+	err = c.do(ctx, http.MethodPost, "/cli/toggle-category",
+		url.Values{"a_or_i": {aliasOrIndex}}, &tm)
+	return
+}
+
+// Delete 删除一条消息。
+func (c *Client) Delete(ctx context.Context, aliasOrIndex string) error {
+	return c.do(ctx, http.MethodPost, "/cli/delete",
+		url.Values{"a_or_i": {aliasOrIndex}}, nil)
+}
+
+// SetAlias 设置消息的别名, alias 为空表示删除别名。
+func (c *Client) SetAlias(ctx context.Context, aliasOrIndex, alias string) error {
+	return c.do(ctx, http.MethodPost, "/cli/set-alias",
+		url.Values{"a_or_i": {aliasOrIndex}, "alias": {alias}}, nil)
+}
+
+// List 从流水号 index 开始 (从 1 开始) 获取最多 limit 条消息 (从新到旧),
+// bucket 是 TempBucket 或 PermBucket.
+func (c *Client) List(ctx context.Context, bucket string, index, limit int) (items []model.TxtMsg, err error) {
+	err = c.do(ctx, http.MethodPost, "/cli/get-more-items", url.Values{
+		"bucket": {bucket},
+		"index":  {strconv.Itoa(index)},
+		"limit":  {strconv.Itoa(limit)},
+	}, &items)
+	return
+}
+
+// Search 查找消息，不指定 buckets 时查找全部消息。
+func (c *Client) Search(ctx context.Context, keyword string, buckets ...string) (items []model.TxtMsg, err error) {
+	form := url.Values{"keyword": {keyword}, "buckets": buckets}
+	err = c.do(ctx, http.MethodPost, "/cli/search", form, &items)
+	return
+}
+
+// Aliases 获取全部别名。
+func (c *Client) Aliases(ctx context.Context) (aliases []model.Alias, err error) {
+	err = c.do(ctx, http.MethodPost, "/cli/get-all-aliases", nil, &aliases)
+	return
+}
+
+// Wait 等待一条比 after (TxtMsg.ID, 空字符串表示当前最新的消息) 更新的暂存消息,
+// timeout 是服务器等待的秒数 (零表示使用服务器的默认值), 超时返回 ErrTimeout.
+func (c *Client) Wait(ctx context.Context, after string, timeout int) (tm model.TxtMsg, err error) {
+	form := url.Values{"after": {after}}
+	if timeout > 0 {
+		form.Set("timeout", strconv.Itoa(timeout))
+	}
+	err = c.do(ctx, http.MethodGet, "/cli/wait", form, &tm)
+	return
+}
+
+// Share 为一条消息生成分享链接, expires 例如 "30m" (空字符串表示永不过期),
+// maxViews 为零表示不限查看次数。返回完整的网址。
+func (c *Client) Share(ctx context.Context, aliasOrIndex, expires string, maxViews int) (share model.Share, link string, err error) {
+	form := url.Values{"a_or_i": {aliasOrIndex}, "expires": {expires}, "max_views": {strconv.Itoa(maxViews)}}
+	var result struct {
+		model.Share
+		Link string
+	}
+	err = c.do(ctx, http.MethodPost, "/cli/share", form, &result)
+	return result.Share, result.Link, err
+}
+
+// QROptions 是 QR 的选项，零值表示使用服务器的默认值 (png, 纠错等级 L)。
+type QROptions struct {
+	Format string // png, svg 或 txt (Unicode 方块字符)
+	Level  string // L, M, Q 或 H
+	Invert bool   // txt 格式用于浅色背景的终端
+}
+
+// QR 返回消息 (token 不为空时则是该分享链接) 的二维码，内容太长时服务器返回 413.
+func (c *Client) QR(ctx context.Context, aliasOrIndex, token string, opt QROptions) ([]byte, error) {
+	form := url.Values{"a_or_i": {aliasOrIndex}, "token": {token}, "format": {opt.Format},
+		"level": {opt.Level}, "invert": {strconv.FormatBool(opt.Invert)}}
+	resp, err := c.send(ctx, http.MethodGet, "/cli/qr", form)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	return io.ReadAll(resp.Body)
+}
+
+// Fingerprint 返回证书 (DER 格式) 的 SHA-256 指纹，格式与
+// "openssl x509 -noout -fingerprint -sha256" 相同，例如 "AB:CD:...".
+func Fingerprint(der []byte) string {
+	sum := sha256.Sum256(der)
+	parts := make([]string, len(sum))
+	for i, b := range sum {
+		parts[i] = fmt.Sprintf("%02X", b)
+	}
+	return strings.Join(parts, ":")
+}
+
+// normalizeFingerprint 忽略大小写、冒号与前缀 "SHA256:", 以便比较指纹。
+func normalizeFingerprint(fp string) string {
+	fp = strings.TrimPrefix(strings.ToUpper(strings.TrimSpace(fp)), "SHA256:")
+	return strings.ReplaceAll(fp, ":", "")
+}
+
+// NewPinned 与 New 相同，但只信任指纹为 fingerprint 的服务器证书 (例如自签名证书),
+// 不再检查证书的签发者与域名。
+func NewPinned(server, key, fingerprint string) *Client {
+	c := New(server, key)
+	want := normalizeFingerprint(fingerprint)
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{
+		InsecureSkipVerify: true, // 由 VerifyPeerCertificate 检查指纹
+		VerifyPeerCertificate: func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			if len(rawCerts) > 0 && normalizeFingerprint(Fingerprint(rawCerts[0])) == want {
+				return nil
+			}
+			return ErrFingerprint
+		},
+	}
+	c.HTTP = &http.Client{Transport: transport}
+	return c
+}
+
+// ServerFingerprint 连接服务器 (不检查证书) 并返回其证书的指纹，
+// 用于在首次登录时与服务器启动时打印的指纹对比。
+func ServerFingerprint(server string) (string, error) {
+	u, err := url.Parse(server)
+	if err != nil {
+		return "", err
+	}
+	if u.Scheme != "https" {
+		return "", fmt.Errorf("not an https URL: %s", server)
+	}
+	host := u.Host
+	if u.Port() == "" {
+		host = net.JoinHostPort(u.Hostname(), "443")
+	}
+	conn, err := tls.Dial("tcp", host, &tls.Config{InsecureSkipVerify: true})
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	certs := conn.ConnectionState().PeerCertificates
+	if len(certs) == 0 {
+		return "", fmt.Errorf("no certificate from %s", host)
+	}
+	return Fingerprint(certs[0].Raw), nil
+}
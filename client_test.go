@@ -0,0 +1,255 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/ahui2016/txt/client"
+	"github.com/ahui2016/txt/model"
+)
+
+// 这些测试使用 client 包访问真正的 handlers (client 包不能引用 main 包，因此放在这里)。
+
+func TestClientMessages(t *testing.T) {
+	srv, key := newTestServer(t)
+	c := client.New(srv.URL, key)
+	ctx := context.Background()
+
+	for _, msg := range []string{"one", "two", "three"} {
+		if err := c.Add(ctx, msg); err != nil {
+			t.Fatalf("Add(%q): %v", msg, err)
+		}
+	}
+	tm, err := c.Get(ctx, "t1")
+	if err != nil || tm.Msg != "three" {
+		t.Fatalf("Get(t1) = %q, %v; want three", tm.Msg, err)
+	}
+
+	items, err := c.List(ctx, client.TempBucket, 1, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := msgs(items); got != "three,two" {
+		t.Errorf("List(temp, 1, 2) = %s; want three,two", got)
+	}
+	if items, err = c.List(ctx, client.TempBucket, 2, 5); err != nil {
+		t.Fatal(err)
+	}
+	if got := msgs(items); got != "two,one" {
+		t.Errorf("List(temp, 2, 5) = %s; want two,one", got)
+	}
+
+	if err := c.SetAlias(ctx, "t3", "first"); err != nil {
+		t.Fatal(err)
+	}
+	if tm, err = c.Get(ctx, "first"); err != nil || tm.Msg != "one" {
+		t.Fatalf("Get(first) = %q, %v; want one", tm.Msg, err)
+	}
+	aliases, err := c.Aliases(ctx)
+	if err != nil || len(aliases) != 1 || aliases[0].ID != "first" {
+		t.Fatalf("Aliases() = %v, %v", aliases, err)
+	}
+	if err := c.SetAlias(ctx, "t2", "first"); err == nil {
+		t.Error("SetAlias with an existing alias: want an error")
+	}
+	if err := c.SetAlias(ctx, "first", ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Get(ctx, "first"); !errors.Is(err, client.ErrNotFound) {
+		t.Errorf("Get(removed alias) error = %v; want ErrNotFound", err)
+	}
+
+	if tm, err = c.Toggle(ctx, "t1"); err != nil || tm.Cat != model.CatPerm {
+		t.Fatalf("Toggle(t1) = %v, %v", tm, err)
+	}
+	if items, err = c.List(ctx, client.PermBucket, 1, 5); err != nil || msgs(items) != "three" {
+		t.Fatalf("List(perm) = %v, %v", items, err)
+	}
+	if items, err = c.Search(ctx, "t", client.TempBucket); err != nil || msgs(items) != "two" {
+		t.Errorf("Search(t, temp) = %v, %v; want two", items, err)
+	}
+	if items, err = c.Search(ctx, "t"); err != nil || len(items) != 2 {
+		t.Errorf("Search(t) = %v, %v; want two items", items, err)
+	}
+
+	if err := c.Delete(ctx, "p1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Delete(ctx, "p1"); !errors.Is(err, client.ErrNotFound) {
+		t.Errorf("Delete(p1) twice: error = %v; want ErrNotFound", err)
+	}
+	if _, err := c.Toggle(ctx, "t9"); !errors.Is(err, client.ErrNotFound) {
+		t.Errorf("Toggle(t9) error = %v; want ErrNotFound", err)
+	}
+}
+
+func msgs(items []model.TxtMsg) string {
+	var s []string
+	for _, item := range items {
+		s = append(s, item.Msg)
+	}
+	return strings.Join(s, ",")
+}
+
+func TestClientAddFrom(t *testing.T) {
+	srv, key := newTestServer(t)
+	c := client.New(srv.URL, key)
+	ctx := context.Background()
+
+	if err := c.AddFrom(ctx, "hello", "laptop"); err == nil {
+		t.Fatal("AddFrom an unknown device: want an error")
+	}
+	if err := db.PutDevice(model.Device{Name: "laptop"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.AddFrom(ctx, "hello", "laptop"); err != nil {
+		t.Fatal(err)
+	}
+	if tm, err := c.Get(ctx, "t1"); err != nil || tm.Device != "laptop" {
+		t.Fatalf("Get(t1) = %v, %v; want device laptop", tm, err)
+	}
+}
+
+func TestClientWait(t *testing.T) {
+	srv, key := newTestServer(t)
+	c := client.New(srv.URL, key)
+	ctx := context.Background()
+
+	if _, err := c.Wait(ctx, "", 1); !errors.Is(err, client.ErrTimeout) {
+		t.Fatalf("Wait() error = %v; want ErrTimeout", err)
+	}
+	if err := c.Add(ctx, "old"); err != nil {
+		t.Fatal(err)
+	}
+	// after 比已有的消息更早时立即返回。
+	old, err := c.Wait(ctx, "0", 1)
+	if err != nil || old.Msg != "old" {
+		t.Fatalf("Wait(0) = %v, %v; want old", old, err)
+	}
+
+	done := make(chan model.TxtMsg)
+	go func() {
+		tm, err := c.Wait(ctx, old.ID, 5)
+		if err != nil {
+			t.Error(err)
+		}
+		done <- tm
+	}()
+	if err := c.Add(ctx, "new"); err != nil {
+		t.Fatal(err)
+	}
+	if tm := <-done; tm.Msg != "new" {
+		t.Errorf("Wait(old) = %q; want new", tm.Msg)
+	}
+}
+
+func TestClientShareAndQR(t *testing.T) {
+	srv, key := newTestServer(t)
+	c := client.New(srv.URL, key)
+	ctx := context.Background()
+
+	if err := c.Add(ctx, "https://example.com"); err != nil {
+		t.Fatal(err)
+	}
+	share, link, err := c.Share(ctx, "t1", "10m", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if share.Token == "" || !strings.HasPrefix(link, srv.URL+"/s/") {
+		t.Fatalf("Share() = %v, %q", share, link)
+	}
+
+	png, err := c.QR(ctx, "t1", "", client.QROptions{})
+	if err != nil || !bytes.HasPrefix(png, []byte("\x89PNG")) {
+		t.Fatalf("QR(png) = %d bytes, %v", len(png), err)
+	}
+	svg, err := c.QR(ctx, "", share.Token, client.QROptions{Format: "svg", Level: "H"})
+	if err != nil || !bytes.HasPrefix(svg, []byte("<svg")) {
+		t.Fatalf("QR(svg) = %q, %v", svg, err)
+	}
+	if _, err := c.QR(ctx, "t1", "", client.QROptions{Level: "X"}); !isStatus(err, http.StatusBadRequest) {
+		t.Errorf("QR(level X) error = %v; want 400", err)
+	}
+	if _, err := c.QR(ctx, "", "no-such-token", client.QROptions{}); !errors.Is(err, client.ErrNotFound) {
+		t.Errorf("QR(unknown token) error = %v; want ErrNotFound", err)
+	}
+	if _, _, err := c.Share(ctx, "t9", "", 0); !errors.Is(err, client.ErrNotFound) {
+		t.Errorf("Share(t9) error = %v; want ErrNotFound", err)
+	}
+}
+
+func isStatus(err error, status int) bool {
+	var e *client.Error
+	return errors.As(err, &e) && e.StatusCode == status
+}
+
+func TestClientWrongKey(t *testing.T) {
+	srv, _ := newTestServer(t)
+	c := client.New(srv.URL, "wrong")
+	if err := c.Add(context.Background(), "hello"); !errors.Is(err, client.ErrWrongKey) {
+		t.Fatalf("Add() with a wrong key: error = %v; want ErrWrongKey", err)
+	}
+	var e *client.Error
+	if err := c.Add(context.Background(), "hello"); !errors.As(err, &e) || e.Code != codeWrongKey {
+		t.Fatalf("error = %#v; want code %s", err, codeWrongKey)
+	}
+}
+
+func TestClientErrorUnwrap(t *testing.T) {
+	tests := []struct {
+		err  client.Error
+		want error
+	}{
+		{client.Error{StatusCode: 401, Code: "wrong_key"}, client.ErrWrongKey},
+		{client.Error{StatusCode: 401, Code: "key_expired"}, client.ErrKeyExpired},
+		{client.Error{StatusCode: 500, Code: "not_found", Message: "reworded"}, client.ErrNotFound},
+		// 旧版本的服务器没有错误代码
+		{client.Error{StatusCode: 401, Message: "wrong key"}, client.ErrWrongKey},
+		{client.Error{StatusCode: 404}, client.ErrNotFound},
+		{client.Error{StatusCode: 500, Message: "wrong key"}, nil},
+	}
+	for _, tt := range tests {
+		if got := tt.err.Unwrap(); got != tt.want {
+			t.Errorf("%#v.Unwrap() = %v; want %v", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestClientPinned(t *testing.T) {
+	newTestDB(t)
+	r, err := newRouter()
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewTLSServer(r)
+	defer srv.Close()
+	key := db.GetConfig().Key
+	ctx := context.Background()
+
+	fp := client.Fingerprint(srv.Certificate().Raw)
+	got, err := client.ServerFingerprint(srv.URL)
+	if err != nil || got != fp {
+		t.Fatalf("ServerFingerprint() = %q, %v; want %q", got, err, fp)
+	}
+	if _, err := client.ServerFingerprint("http://example.com"); err == nil {
+		t.Error("ServerFingerprint(http URL): want an error")
+	}
+
+	if _, err := client.New(srv.URL, key).Aliases(ctx); err == nil {
+		t.Error("New() trusts a self-signed certificate")
+	}
+	// 指纹不区分大小写，可以带前缀 SHA256:
+	pinned := client.NewPinned(srv.URL, key, "SHA256:"+strings.ToLower(fp))
+	if _, err := pinned.Aliases(ctx); err != nil {
+		t.Fatal(err)
+	}
+	wrong := client.NewPinned(srv.URL, key, strings.Repeat("00:", 31)+"00")
+	if _, err := wrong.Aliases(ctx); !errors.Is(err, client.ErrFingerprint) {
+		t.Errorf("wrong fingerprint: error = %v; want ErrFingerprint", err)
+	}
+}
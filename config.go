@@ -0,0 +1,184 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/ahui2016/txt/util"
+	"gopkg.in/yaml.v2"
+)
+
+// 服务器配置文件，默认保存在 appConfigFolder 里。
+const configFileName = "config.yaml"
+
+// envPrefix 是环境变量的前缀，例如 -session-max-age 对应 TXT_SESSION_MAX_AGE.
+const envPrefix = "TXT_"
+
+// secretFlags 里的参数在 txt config print 中不显示具体内容。
+var secretFlags = map[string]bool{
+	"peer-key":    true,
+	"smtp-secret": true,
+	"hooks":       true,
+}
+
+// configInfo 记录配置文件的位置与每个参数的来源。
+type configInfo struct {
+	Path    string            // 配置文件，不存在时为空字符串
+	Sources map[string]string // 参数名 => flag, env, file
+}
+
+// envName 返回参数对应的环境变量名。
+func envName(flagName string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+// applyConfig 把环境变量与配置文件的内容设置到 fs 中尚未在命令行指定的参数,
+// 优先级: 命令行参数 > 环境变量 > 配置文件 > 默认值。
+// 配置文件的键名与参数名相同 (不带 "-"), 例如 addr, db, trusted-proxies.
+func applyConfig(fs *flag.FlagSet) (info configInfo, err error) {
+	info.Sources = make(map[string]string)
+	fs.Visit(func(f *flag.Flag) {
+		info.Sources[f.Name] = "flag"
+	})
+	path, explicit := *configFile, true
+	if path == "" {
+		path = os.Getenv(envName("config"))
+	}
+	if path == "" {
+		path, explicit = filepath.Join(appConfigFolder(), configFileName), false
+	}
+	file, err := readConfigFile(path)
+	if errors.Is(err, os.ErrNotExist) && !explicit {
+		path, err = "", nil
+	}
+	if err != nil {
+		return info, err
+	}
+	info.Path = path
+
+	for key := range file {
+		if fs.Lookup(key) == nil || key == "config" {
+			return info, fmt.Errorf("%s: unknown option: %s", path, key)
+		}
+	}
+	var errs []error
+	fs.VisitAll(func(f *flag.Flag) {
+		if _, ok := info.Sources[f.Name]; ok || f.Name == "config" {
+			return
+		}
+		if value, ok := os.LookupEnv(envName(f.Name)); ok {
+			if err := fs.Set(f.Name, value); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", envName(f.Name), err))
+			}
+			info.Sources[f.Name] = "env"
+		} else if value, ok := file[f.Name]; ok {
+			if err := fs.Set(f.Name, value); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %s: %w", path, f.Name, err))
+			}
+			info.Sources[f.Name] = "file"
+		}
+	})
+	if len(errs) > 0 {
+		return info, util.WrapErrors(errs...)
+	}
+	return info, checkConfig()
+}
+
+// readConfigFile 读取 YAML 配置文件，把每个值都转换为字符串 (列表转换为逗号分隔的字符串)。
+func readConfigFile(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var raw map[string]interface{}
+	if err := yaml.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	file := make(map[string]string, len(raw))
+	for key, value := range raw {
+		switch v := value.(type) {
+		case nil:
+			file[key] = ""
+		case []interface{}:
+			items := make([]string, len(v))
+			for i, item := range v {
+				items[i] = fmt.Sprint(item)
+			}
+			file[key] = strings.Join(items, ",")
+		case map[interface{}]interface{}:
+			return nil, fmt.Errorf("%s: %s: nested options are not supported", path, key)
+		default:
+			file[key] = fmt.Sprint(v)
+		}
+	}
+	return file, nil
+}
+
+// checkConfig 检查不能用参数类型限制的取值范围。
+func checkConfig() error {
+	switch {
+	case *sessionMaxAge <= 0:
+		return fmt.Errorf("session-max-age must be positive")
+	case *passwordMaxTry < 1 || *allIP_MaxTry < 1:
+		return fmt.Errorf("password-max-try and all-ip-max-try must be at least 1")
+	case *recentItems < 1:
+		return fmt.Errorf("recent-items must be at least 1")
+	case *changelogKeep < 0:
+		return fmt.Errorf("changelog-keep must not be negative")
+	}
+	return nil
+}
+
+// splitList 把逗号分隔的字符串转换为列表，忽略空白项。
+func splitList(s string) (list []string) {
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return
+}
+
+// printConfig 以配置文件的格式输出全部参数的当前值，并注明来源。
+func printConfig(w io.Writer, fs *flag.FlagSet, info configInfo) {
+	if info.Path != "" {
+		fmt.Fprintln(w, "# config file:", info.Path)
+	} else {
+		fmt.Fprintln(w, "# config file: none")
+	}
+	var names []string
+	fs.VisitAll(func(f *flag.Flag) {
+		if f.Name != "config" {
+			names = append(names, f.Name)
+		}
+	})
+	sort.Strings(names)
+	for _, name := range names {
+		f := fs.Lookup(name)
+		var value interface{} = f.Value.String()
+		if getter, ok := f.Value.(flag.Getter); ok {
+			switch v := getter.Get().(type) {
+			case bool, int:
+				value = v
+			}
+		}
+		if secretFlags[name] && f.Value.String() != "" {
+			value = "(hidden)"
+		}
+		out, _ := yaml.Marshal(map[string]interface{}{name: value})
+		source := info.Sources[name]
+		switch source {
+		case "":
+			source = "default"
+		case "env":
+			source = "env " + envName(name)
+		}
+		fmt.Fprintf(w, "%s  # %s\n", strings.TrimSpace(string(out)), source)
+	}
+}
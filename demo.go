@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/ahui2016/txt/model"
+	"github.com/ahui2016/txt/mydb"
+	"github.com/ahui2016/txt/util"
+	"github.com/gin-gonic/gin"
+)
+
+// demoItems 是演示模式的初始数据，每次重置数据库时都会恢复为这些消息。
+var demoItems = []struct {
+	Alias string
+	Msg   string
+	Cat   model.Category
+}{
+	{"", "欢迎试用 txt, 这是一条暂存消息。", model.CatTemp},
+	{"", "txt get t1", model.CatTemp},
+	{"", "https://github.com/ahui2016/txt", model.CatTemp},
+	{"", "Hello, this is a temporary message.", model.CatTemp},
+	{"email", "someone@example.com", model.CatPerm},
+	{"gitlog", "git log --oneline --graph --all", model.CatPerm},
+	{"", "永久消息不会被自动删除。", model.CatPerm},
+}
+
+// seedDemo 把演示数据库恢复为初始状态。
+func seedDemo(memDB *mydb.MemDB) {
+	offset := memDB.GetConfig().TimeOffset
+	now := time.Now()
+	var items []model.TxtMsg
+	for i, item := range demoItems {
+		// 越靠前的消息越新
+		id, err := model.DateIDAt(now.Add(-time.Duration(i)*time.Minute), offset)
+		util.Panic(err)
+		items = append(items, model.TxtMsg{
+			ID:    id,
+			Alias: item.Alias,
+			Msg:   item.Msg,
+			Cat:   item.Cat,
+		})
+	}
+	memDB.Reset(items)
+}
+
+// demoResetLoop 每隔 demoReset 重置一次演示数据库，同时清空各 IP 的计数。
+func demoResetLoop(ctx context.Context, memDB *mydb.MemDB) {
+	if *demoReset <= 0 {
+		return
+	}
+	ticker := time.NewTicker(*demoReset)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			seedDemo(memDB)
+			demoCounter.reset()
+			log.Print("[Demo] database reset")
+		}
+	}
+}
+
+// ipCounter 记录每个 IP 在一定时间内的次数。
+type ipCounter struct {
+	sync.Mutex
+	count map[string]int
+}
+
+func newIPCounter() *ipCounter {
+	return &ipCounter{count: make(map[string]int)}
+}
+
+// add 给 ip 的计数加一，如果加一之前已达到 max 则返回 false.
+func (ic *ipCounter) add(ip string, max int) bool {
+	ic.Lock()
+	defer ic.Unlock()
+	if ic.count[ip] >= max {
+		return false
+	}
+	ic.count[ip]++
+	return true
+}
+
+func (ic *ipCounter) reset() {
+	ic.Lock()
+	defer ic.Unlock()
+	ic.count = make(map[string]int)
+}
+
+var (
+	demoCounter = newIPCounter() // 每个 IP 在两次重置之间添加的消息数量
+	rateCounter = newIPCounter() // 每个 IP 在一分钟内的请求数量
+)
+
+// rateResetLoop 每分钟清空一次请求计数。
+func rateResetLoop(ctx context.Context) {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			rateCounter.reset()
+		}
+	}
+}
+
+// DemoRateLimit 在演示模式中限制每个 IP 每分钟的请求次数。
+func DemoRateLimit() gin.HandlerFunc {
+	return demoRateLimit(abortText)
+}
+
+// V2DemoRateLimit 与 DemoRateLimit 相同，但使用 v2 的错误格式。
+func V2DemoRateLimit() gin.HandlerFunc {
+	return demoRateLimit(v2Abort)
+}
+
+func demoRateLimit(abort abortFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if *demo && !rateCounter.add(c.ClientIP(), *demoRate) {
+			abort(c, http.StatusTooManyRequests, codeRateLimited,
+				"Demo Mode (演示模式) 请求过于频繁，请稍后再试。")
+			return
+		}
+		c.Next()
+	}
+}
+
+// DemoMsgLimit 在演示模式中限制每个 IP 添加消息的数量。
+func DemoMsgLimit() gin.HandlerFunc {
+	return demoMsgLimit(abortText)
+}
+
+// V2DemoMsgLimit 与 DemoMsgLimit 相同，但使用 v2 的错误格式。
+func V2DemoMsgLimit() gin.HandlerFunc {
+	return demoMsgLimit(v2Abort)
+}
+
+func demoMsgLimit(abort abortFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if *demo && !demoCounter.add(c.ClientIP(), *demoMaxMsg) {
+			abort(c, http.StatusTooManyRequests, codeMsgLimit,
+				"Demo Mode (演示模式) 已达到消息数量上限，请等待数据重置。")
+			return
+		}
+		c.Next()
+	}
+}
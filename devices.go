@@ -0,0 +1,218 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/ahui2016/txt/model"
+	"github.com/ahui2016/txt/mydb"
+	"github.com/ahui2016/txt/util"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+const deviceTokenSize = 18
+
+// DeviceRegistration 是 "/cli/register-device" 的返回结果。
+// Token 只在登记时返回这一次，此后无法再查看，忘记了只能重新登记。
+type DeviceRegistration struct {
+	model.Device
+	Token string
+}
+
+func registerDeviceHandler(c *gin.Context) {
+	type form struct {
+		Name     string   `form:"device" binding:"required"`
+		SkipSelf bool     `form:"skip_self"`
+		Mute     []string `form:"mute"`
+	}
+	var f form
+	if BindCheck(c, &f) {
+		return
+	}
+	device := model.Device{
+		Name:     f.Name,
+		Token:    base64.RawURLEncoding.EncodeToString(util.RandomBytes(deviceTokenSize)),
+		SkipSelf: f.SkipSelf,
+		Mute:     f.Mute,
+	}
+	if checkErr(c, db.PutDevice(device)) {
+		return
+	}
+	device, err := db.GetDevice(f.Name)
+	if checkErr(c, err) {
+		return
+	}
+	c.JSON(OK, DeviceRegistration{Device: device, Token: device.Token})
+}
+
+func getDevicesHandler(c *gin.Context) {
+	devices, err := db.GetDevices()
+	if checkErr(c, err) {
+		return
+	}
+	c.JSON(OK, devices)
+}
+
+func deleteDeviceHandler(c *gin.Context) {
+	type form struct {
+		Name string `form:"device" binding:"required"`
+	}
+	var f form
+	if BindCheck(c, &f) {
+		return
+	}
+	checkErr(c, db.DeleteDevice(f.Name))
+}
+
+// checkDevice 检查设备是否已登记 (name 为空表示不指定设备)，返回 true 表示有错误。
+func checkDevice(c *gin.Context, name string) (exit bool) {
+	if name == "" {
+		return false
+	}
+	_, err := db.GetDevice(name)
+	if errors.Is(err, mydb.ErrNoResult) {
+		c.JSON(http.StatusBadRequest, Text{"unknown device: " + name})
+		return true
+	}
+	return checkErr(c, err)
+}
+
+// WsMessage 是剪贴板同步通道中双方收发的消息。
+// 服务器推送 Type 为 "msg" (附带 Msg) 或 "error" (附带 Message) 的消息;
+// 设备发送 Type 为 "send" 的消息 (附带 Message), 相当于 "/cli/add".
+type WsMessage struct {
+	Type    string        `json:"type"`
+	Msg     *model.TxtMsg `json:"msg,omitempty"`
+	Message string        `json:"message,omitempty"`
+}
+
+// wsOverhead 是 WsMessage 除消息内容以外的部分 (键名、引号等) 的长度上限。
+const wsOverhead = 1024
+
+var wsUpgrader = websocket.Upgrader{
+	// 已通过密钥验证身份，因此不检查 Origin.
+	CheckOrigin: func(r *http.Request) bool { return true },
+}
+
+// clipboardHandler 是剪贴板同步通道 (WebSocket)。
+// 设备必须先登记，并使用登记时得到的 token 连接，
+// 连接后会收到其他设备发送的每一条新的暂存消息。
+func clipboardHandler(c *gin.Context) {
+	type form struct {
+		Name  string `form:"device" binding:"required"`
+		Token string `form:"token" binding:"required"`
+	}
+	var f form
+	if BindCheck(c, &f) {
+		return
+	}
+	device, err := db.GetDevice(f.Name)
+	if errors.Is(err, mydb.ErrNoResult) {
+		c.JSON(http.StatusBadRequest, Text{"unknown device: " + f.Name})
+		return
+	}
+	if checkErr(c, err) {
+		return
+	}
+	// 旧版本登记的设备没有 token, 需要重新登记。
+	if device.Token == "" || subtle.ConstantTimeCompare([]byte(f.Token), []byte(device.Token)) != 1 {
+		c.JSON(http.StatusUnauthorized, Text{"wrong device token"})
+		return
+	}
+	conn, err := wsUpgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		return // Upgrade 已向客户端返回错误
+	}
+	defer conn.Close()
+
+	changes, cancel := db.Subscribe()
+	defer cancel()
+
+	// 读取设备发送的消息，同时用于发现连接断开。
+	// 本函数返回时关闭 done (然后关闭 conn), 以免读取的 goroutine 阻塞在 incoming 上。
+	conn.SetReadLimit(int64(db.GetConfig().MsgSizeLimit) + wsOverhead)
+	incoming := make(chan WsMessage)
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		defer close(incoming)
+		for {
+			var msg WsMessage
+			if err := conn.ReadJSON(&msg); err != nil {
+				return
+			}
+			select {
+			case incoming <- msg:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	ping := time.NewTicker(heartbeatInterval)
+	defer ping.Stop()
+	for {
+		select {
+		case <-c.Request.Context().Done():
+			return
+		case <-streams.Done():
+			return
+		case <-ping.C:
+			deadline := time.Now().Add(heartbeatInterval)
+			if err := conn.WriteControl(websocket.PingMessage, nil, deadline); err != nil {
+				return
+			}
+		case msg, ok := <-incoming:
+			if !ok {
+				return
+			}
+			if err := wsSend(c, f.Name, msg); err != nil {
+				if conn.WriteJSON(WsMessage{Type: "error", Message: err.Error()}) != nil {
+					return
+				}
+			}
+		case ch, ok := <-changes:
+			if !ok {
+				return // 积压太多被关闭，设备重连即可。
+			}
+			if ch.Op != model.OpInsert || ch.Msg.Cat != model.CatTemp {
+				continue
+			}
+			// 每次都重新读取，以便及时反映设备设置的修改。
+			device, err := db.GetDevice(f.Name)
+			if err != nil {
+				return // 设备已被删除
+			}
+			if !device.Wants(ch.Msg) {
+				continue
+			}
+			tm := ch.Msg
+			if conn.WriteJSON(WsMessage{Type: "msg", Msg: &tm}) != nil {
+				return
+			}
+		}
+	}
+}
+
+// wsSend 把设备通过 WebSocket 发送的内容保存为一条新消息。
+func wsSend(c *gin.Context, device string, msg WsMessage) error {
+	if msg.Type != "send" {
+		return errors.New("unknown message type: " + msg.Type)
+	}
+	if *readonly {
+		return errors.New("Read-only Mode (只读模式) 不可修改数据。")
+	}
+	if *demo && !demoCounter.add(c.ClientIP(), *demoMaxMsg) {
+		return errors.New("Demo Mode (演示模式) 已达到消息数量上限，请等待数据重置。")
+	}
+	tm, err := db.NewTxtMsg(msg.Message)
+	if err != nil {
+		return err
+	}
+	tm.Device = device
+	return db.InsertTxtMsg(tm)
+}
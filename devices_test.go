@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestClipboard(t *testing.T) {
+	srv, key := newTestServer(t)
+	resp, err := http.PostForm(srv.URL+"/cli/register-device", url.Values{"password": {key}, "device": {"phone"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var reg DeviceRegistration
+	err = json.NewDecoder(resp.Body).Decode(&reg)
+	resp.Body.Close()
+	if err != nil || resp.StatusCode != OK || reg.Token == "" {
+		t.Fatalf("register device: %d %v %+v", resp.StatusCode, err, reg)
+	}
+
+	// 只知道设备名称不能冒充该设备
+	header := http.Header{"Authorization": {"Bearer " + key}}
+	wsURL := "ws" + strings.TrimPrefix(srv.URL, "http") + "/cli/clipboard?device=phone&token="
+	for _, token := range []string{"", "wrong-token"} {
+		_, resp, err := websocket.DefaultDialer.Dial(wsURL+token, header)
+		if err == nil || resp == nil || (resp.StatusCode != http.StatusUnauthorized && resp.StatusCode != http.StatusBadRequest) {
+			t.Errorf("token %q: %v; want the connection refused", token, err)
+		}
+	}
+	// 设备列表中不包含 token
+	resp, err = http.PostForm(srv.URL+"/cli/get-devices", url.Values{"password": {key}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if strings.Contains(string(body), reg.Token) {
+		t.Errorf("/cli/get-devices returns the device token: %s", body)
+	}
+
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL+url.QueryEscape(reg.Token), header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	if err := conn.WriteJSON(WsMessage{Type: "send", Message: "hello"}); err != nil {
+		t.Fatal(err)
+	}
+	var msg WsMessage
+	if err := conn.ReadJSON(&msg); err != nil {
+		t.Fatal(err)
+	}
+	if msg.Type != "msg" || msg.Msg == nil || msg.Msg.Msg != "hello" || msg.Msg.Device != "phone" {
+		t.Errorf("got %+v; want the new message", msg)
+	}
+
+	if err := conn.WriteJSON(WsMessage{Type: "paste"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.ReadJSON(&msg); err != nil || msg.Type != "error" {
+		t.Errorf("unknown type: got %+v, %v; want an error message", msg, err)
+	}
+
+	// 超过长度上限时，服务器关闭连接而不是读入整条消息
+	long := strings.Repeat("a", db.GetConfig().MsgSizeLimit+wsOverhead)
+	if err := conn.WriteJSON(WsMessage{Type: "send", Message: long}); err != nil {
+		t.Fatal(err)
+	}
+	_, _, err = conn.ReadMessage()
+	if !websocket.IsCloseError(err, websocket.CloseMessageTooBig) {
+		t.Errorf("oversized message: %v; want close 1009", err)
+	}
+}
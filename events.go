@@ -0,0 +1,108 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/ahui2016/txt/model"
+	"github.com/gin-contrib/sse"
+	"github.com/gin-gonic/gin"
+)
+
+// 每隔多久发送一次心跳，避免连接被代理服务器或浏览器断开。
+const heartbeatInterval = 15 * time.Second
+
+// writeChangeEvent 发送一条 SSE 事件, 其中 id 是修改记录的流水号,
+// event 是修改类型 (insert, edit, alias, toggle, delete)。
+func writeChangeEvent(c *gin.Context, ch model.Change) error {
+	err := sse.Encode(c.Writer, sse.Event{
+		Id:    strconv.FormatUint(ch.Seq, 10),
+		Event: string(ch.Op),
+		Data:  ch,
+	})
+	c.Writer.Flush()
+	return err
+}
+
+// eventsHandler 以 Server-Sent Events 的形式推送修改记录。
+// 断线重连时，浏览器会自动发送 Last-Event-ID, 服务器会先补发遗漏的修改记录。
+// 命令行工具也可使用 last_event_id 参数。
+func eventsHandler(c *gin.Context) {
+	lastID := c.GetHeader("Last-Event-ID")
+	if lastID == "" {
+		lastID = c.Query("last_event_id")
+	}
+	var since uint64
+	if lastID != "" {
+		n, err := strconv.ParseUint(lastID, 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, Text{"invalid Last-Event-ID"})
+			return
+		}
+		since = n
+	}
+
+	if lastID != "" {
+		// 在开始推送之前检查，以便返回错误状态码 (否则客户端会不断重连)。
+		_, err := db.ChangesSince(since, 1)
+		if checkChangesErr(c, err) {
+			return
+		}
+	}
+
+	// 必须先订阅再补发，以免遗漏两者之间发生的修改。
+	changes, cancel := db.Subscribe()
+	defer cancel()
+
+	c.Header("Content-Type", sse.ContentType)
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no") // 告诉 nginx 不要缓冲
+	c.Status(OK)
+	c.Writer.Flush()
+
+	if lastID != "" {
+		for {
+			missed, err := db.ChangesSince(since, changesLimit)
+			if err != nil {
+				return
+			}
+			for _, ch := range missed {
+				if writeChangeEvent(c, ch) != nil {
+					return
+				}
+				since = ch.Seq
+			}
+			if len(missed) < changesLimit {
+				break
+			}
+		}
+	}
+
+	heartbeat := time.NewTicker(heartbeatInterval)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-c.Request.Context().Done():
+			return
+		case <-streams.Done():
+			return
+		case <-heartbeat.C:
+			if _, err := c.Writer.WriteString(": heartbeat\n\n"); err != nil {
+				return
+			}
+			c.Writer.Flush()
+		case ch, ok := <-changes:
+			if !ok {
+				return // 积压太多被关闭，客户端重连时会补发。
+			}
+			if ch.Seq <= since {
+				continue // 已经补发过了
+			}
+			if writeChangeEvent(c, ch) != nil {
+				return
+			}
+			since = ch.Seq
+		}
+	}
+}
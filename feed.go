@@ -0,0 +1,248 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/ahui2016/txt/model"
+	"github.com/ahui2016/txt/mydb"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	feedDefaultLimit = 20
+	feedMaxLimit     = 100
+	feedTitleLength  = 60 // 条目标题最多包含多少个字符
+)
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	ID      string      `xml:"id"`
+	Title   string      `xml:"title"`
+	Updated string      `xml:"updated"`
+	Author  atomPerson  `xml:"author"`
+	Link    atomLink    `xml:"link"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomPerson struct {
+	Name string `xml:"name"`
+}
+
+type atomLink struct {
+	Rel  string `xml:"rel,attr"`
+	Href string `xml:"href,attr"`
+}
+
+type atomCategory struct {
+	Term string `xml:"term,attr"`
+}
+
+type atomText struct {
+	Type string `xml:"type,attr"`
+	Body string `xml:",chardata"`
+}
+
+type atomEntry struct {
+	ID        string       `xml:"id"`
+	Title     string       `xml:"title"`
+	Published string       `xml:"published"`
+	Updated   string       `xml:"updated"`
+	Category  atomCategory `xml:"category"`
+	Content   atomText     `xml:"content"`
+}
+
+// feedHandler 返回 Atom feed, 参数 tag, alias, bucket 三选一:
+// tag 表示包含 "#tag" 的消息, alias 表示别名以此开头的消息, 否则是 bucket (temp 或 perm) 里最新的消息。
+// 支持 ETag (If-None-Match) 与 If-Modified-Since. 编辑、删除消息不会改变消息的 ID,
+// 因此 Last-Modified 使用最后一条修改记录的时间 (见 feedModTime)。
+func feedHandler(c *gin.Context) {
+	type query struct {
+		Bucket string `form:"bucket"`
+		Tag    string `form:"tag"`
+		Alias  string `form:"alias"`
+		Limit  int    `form:"limit" binding:"min=0"`
+	}
+	var q query
+	if BindCheck(c, &q) {
+		return
+	}
+	if q.Tag != "" && q.Alias != "" {
+		c.JSON(http.StatusBadRequest, Text{"use either tag or alias, not both"})
+		return
+	}
+	if q.Limit == 0 {
+		q.Limit = feedDefaultLimit
+	}
+	if q.Limit > feedMaxLimit {
+		q.Limit = feedMaxLimit
+	}
+
+	var items []model.TxtMsg
+	var err error
+	var title string
+	switch {
+	case q.Tag != "":
+		title = "#" + strings.TrimPrefix(q.Tag, "#")
+		items, err = feedTagItems(strings.TrimPrefix(q.Tag, "#"))
+	case q.Alias != "":
+		title = "alias: " + q.Alias + "*"
+		items, err = feedAliasItems(q.Alias)
+	default:
+		bucket, ok := v2Bucket(q.Bucket)
+		if !ok {
+			c.JSON(http.StatusBadRequest, Text{"unknown bucket: " + q.Bucket})
+			return
+		}
+		title = "temp"
+		if bucket == mydb.PermBucket {
+			title = "perm"
+		}
+		items, err = db.CliGetTxtMsg(bucket, 1, q.Limit)
+	}
+	if checkErr(c, err) {
+		return
+	}
+	// CliGetTxtMsg 已按从新到旧排列，其他两种需要排序。
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].ID > items[j].ID
+	})
+	if len(items) > q.Limit {
+		items = items[:q.Limit]
+	}
+
+	feed, err := newAtomFeed(c, "txt - "+title, items)
+	if checkErr(c, err) {
+		return
+	}
+	modTime, err := feedModTime()
+	if checkErr(c, err) {
+		return
+	}
+	body, err := xml.MarshalIndent(feed, "", "  ")
+	if checkErr(c, err) {
+		return
+	}
+	body = append([]byte(xml.Header), body...)
+	sum := sha256.Sum256(body)
+	c.Header("ETag", `"`+hex.EncodeToString(sum[:16])+`"`)
+	c.Header("Content-Type", "application/atom+xml; charset=utf-8")
+	c.Header("Cache-Control", "private, no-cache")
+	// ServeContent 会处理 If-None-Match 与 If-Modified-Since, 并在需要时返回 304.
+	http.ServeContent(c.Writer, c.Request, "", modTime, bytes.NewReader(body))
+}
+
+// feedModTime 返回最后一条修改记录写入的时间 (插入、编辑、删除等每次修改都会产生一条修改记录)。
+// 全部 feed 共用这个时间，它可能晚于某个 feed 实际的修改时间，但不会更早，因此阅读器不会错过修改。
+// 没有任何修改记录时返回零值，此时不提供 Last-Modified. 最新的修改就在这一秒内时也返回零值，
+// 因为 Last-Modified 只精确到秒，同一秒内之后的修改不会改变它。
+func feedModTime() (time.Time, error) {
+	last, err := db.LastRecorded()
+	if err != nil || last == 0 || last >= time.Now().Unix() {
+		return time.Time{}, err
+	}
+	return time.Unix(last, 0), nil
+}
+
+// feedTagItems 返回包含 "#tag" 的消息 (不区分大小写, 不包括 "#tags" 这样更长的标签)。
+func feedTagItems(tag string) ([]model.TxtMsg, error) {
+	if tag == "" {
+		return nil, nil
+	}
+	items, err := db.SearchTxtMsg("#"+tag, nil)
+	if err != nil {
+		return nil, err
+	}
+	re := regexp.MustCompile(`(?i)(^|\s)#` + regexp.QuoteMeta(tag) + `($|[^\p{L}\p{N}_-])`)
+	var tagged []model.TxtMsg
+	for _, tm := range items {
+		if re.MatchString(tm.Msg) {
+			tagged = append(tagged, tm)
+		}
+	}
+	return tagged, nil
+}
+
+// feedAliasItems 返回别名以 prefix 开头的消息。
+func feedAliasItems(prefix string) (items []model.TxtMsg, err error) {
+	aliases, err := db.GetAllAliases()
+	if err != nil {
+		return nil, err
+	}
+	for _, alias := range aliases {
+		if !strings.HasPrefix(alias.ID, prefix) {
+			continue
+		}
+		tm, err := db.GetByID(alias.MsgID)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, tm)
+	}
+	return
+}
+
+// newAtomFeed 生成 feed, 其更新时间是最新一条消息的时间。
+// 每个条目的 id 与时间都来自 TxtMsg.ID.
+func newAtomFeed(c *gin.Context, title string, items []model.TxtMsg) (feed atomFeed, err error) {
+	offset := db.GetConfig().TimeOffset
+	// 网址中不包含密钥
+	query := c.Request.URL.Query()
+	query.Del("password")
+	self := baseURL(c) + c.Request.URL.Path
+	if len(query) > 0 {
+		self += "?" + query.Encode()
+	}
+
+	feed = atomFeed{
+		ID:     "urn:txt:feed:" + url.QueryEscape(query.Encode()),
+		Title:  title,
+		Author: atomPerson{Name: "txt"},
+		Link:   atomLink{Rel: "self", Href: self},
+	}
+	var modTime time.Time
+	for _, tm := range items {
+		t, err := model.DateIDTime(tm.ID, offset)
+		if err != nil {
+			return feed, err
+		}
+		if t.After(modTime) {
+			modTime = t
+		}
+		updated := t.Format(time.RFC3339)
+		feed.Entries = append(feed.Entries, atomEntry{
+			ID:        "urn:txt:msg:" + tm.ID,
+			Title:     feedEntryTitle(tm),
+			Published: updated,
+			Updated:   updated,
+			Category:  atomCategory{Term: string(tm.Cat)},
+			Content:   atomText{Type: "text", Body: tm.Msg},
+		})
+	}
+	if modTime.IsZero() {
+		modTime = time.Unix(0, 0)
+	}
+	feed.Updated = modTime.Format(time.RFC3339)
+	return
+}
+
+// feedEntryTitle 使用别名与消息的第一行作为标题。
+func feedEntryTitle(tm model.TxtMsg) string {
+	title := strings.TrimSpace(strings.SplitN(tm.Msg, "\n", 2)[0])
+	if utf8.RuneCountInString(title) > feedTitleLength {
+		title = string([]rune(title)[:feedTitleLength]) + "…"
+	}
+	if tm.Alias != "" {
+		title = "[" + tm.Alias + "] " + title
+	}
+	return title
+}
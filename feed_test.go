@@ -0,0 +1,87 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/ahui2016/txt/model"
+	"github.com/ahui2016/txt/mydb"
+)
+
+// Last-Modified 来自最后一条修改记录，因此编辑、删除消息后 ETag 与 Last-Modified 都会改变,
+// 只发送 If-Modified-Since 的阅读器也能得到新的内容。
+func TestFeedValidators(t *testing.T) {
+	srv, key := newTestServer(t)
+	id, err := model.DateIDAt(time.Now(), mydb.BeijingTime)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.InsertTxtMsg(model.TxtMsg{ID: id, Msg: "hello", Cat: model.CatTemp}); err != nil {
+		t.Fatal(err)
+	}
+	get := func(header http.Header) *http.Response {
+		t.Helper()
+		req, err := http.NewRequest("GET", srv.URL+"/feed", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header = header
+		req.Header.Set("Authorization", "Bearer "+key)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+
+	time.Sleep(time.Second) // 修改就在这一秒内时不提供 Last-Modified
+	resp := get(http.Header{})
+	etag, lastModified := resp.Header.Get("ETag"), resp.Header.Get("Last-Modified")
+	if resp.StatusCode != OK || etag == "" || lastModified == "" {
+		t.Fatalf("got %d, ETag %q, Last-Modified %q", resp.StatusCode, etag, lastModified)
+	}
+	if resp := get(http.Header{"If-None-Match": {etag}}); resp.StatusCode != http.StatusNotModified {
+		t.Errorf("same ETag: %d; want 304", resp.StatusCode)
+	}
+	if resp := get(http.Header{"If-Modified-Since": {lastModified}}); resp.StatusCode != http.StatusNotModified {
+		t.Errorf("If-Modified-Since %s: %d; want 304", lastModified, resp.StatusCode)
+	}
+
+	// 来自 peer 的修改保留原来的 (较旧的) 时间，也要使 Last-Modified 改变
+	old := model.TxtMsg{ID: "2021-01-01_000000", Msg: "old", Cat: model.CatTemp}
+	remote := model.Change{Op: model.OpInsert, ID: old.ID, Msg: old,
+		Version: model.Version{Time: 100, Origin: "peer", OriginSeq: 1}}
+	for _, step := range []struct {
+		name   string
+		modify func() error
+	}{
+		{"edit", func() error { return db.Edit(model.EditForm{ID: id, Msg: "edited"}) }},
+		{"delete", func() error { return db.DeleteTxtMsg(id) }},
+		{"older remote change", func() error { _, err := db.ApplyChanges([]model.Change{remote}); return err }},
+	} {
+		if err := step.modify(); err != nil {
+			t.Fatal(err)
+		}
+		// 阅读器只发送 If-Modified-Since 时也必须得到新的内容
+		resp := get(http.Header{"If-Modified-Since": {lastModified}})
+		if resp.StatusCode != OK || resp.Header.Get("ETag") == etag {
+			t.Errorf("after %s: %d, ETag %s; want 200 and a new ETag", step.name, resp.StatusCode, resp.Header.Get("ETag"))
+		}
+		etag = resp.Header.Get("ETag")
+	}
+	time.Sleep(time.Second)
+	if resp := get(http.Header{"If-Modified-Since": {lastModified}}); resp.StatusCode != OK ||
+		!later(resp.Header.Get("Last-Modified"), lastModified) {
+		t.Errorf("a second later: %d, Last-Modified %s; want 200 and later than %s",
+			resp.StatusCode, resp.Header.Get("Last-Modified"), lastModified)
+	}
+}
+
+// later 判断 HTTP 时间 a 是否晚于 b.
+func later(a, b string) bool {
+	ta, err1 := http.ParseTime(a)
+	tb, err2 := http.ParseTime(b)
+	return err1 == nil && err2 == nil && ta.After(tb)
+}
@@ -23,9 +23,11 @@ type Text struct {
 	Message string `json:"message"`
 }
 
+// checkErr 为了兼容旧的客户端，状态码总是 500, 但附带与 v2 相同的错误代码 (见 errorCode)。
 func checkErr(c *gin.Context, err error) bool {
 	if err != nil {
-		c.JSON(500, Text{err.Error()})
+		_, code := errorCode(err)
+		c.JSON(500, APIError{Code: code, Message: err.Error()})
 		return true
 	}
 	return false
@@ -98,7 +100,7 @@ func signInHandler(c *gin.Context) {
 	if BindCheck(c, &form) {
 		return
 	}
-	if checkKeyAndIP(c, form.Password) {
+	if checkKeyAndIP(c, form.Password, v2Abort) {
 		return
 	}
 	session := sessions.Default(c)
@@ -137,7 +139,7 @@ func getCurrentKey(c *gin.Context) {
 	if checkPwdAndIP(c, form.Password) {
 		return
 	}
-	writeKeyResult(c, db.Config)
+	writeKeyResult(c, db.GetConfig())
 }
 
 func generateKeyHandler(c *gin.Context) {
@@ -155,7 +157,7 @@ func generateKeyHandler(c *gin.Context) {
 	if checkErr(c, db.GenNewKey()) {
 		return
 	}
-	writeKeyResult(c, db.Config)
+	writeKeyResult(c, db.GetConfig())
 }
 
 type ChangePwdForm struct {
@@ -180,21 +182,26 @@ func changePwdHandler(c *gin.Context) {
 
 func addTxtMsg(c *gin.Context) {
 	type form struct {
-		Msg string `form:"msg" binding:"required"`
+		Msg    string `form:"msg" binding:"required"`
+		Device string `form:"device"`
 	}
 	var f form
 	if BindCheck(c, &f) {
 		return
 	}
+	if checkDevice(c, f.Device) {
+		return
+	}
 	msg, err := db.NewTxtMsg(f.Msg)
 	if checkErr(c, err) {
 		return
 	}
+	msg.Device = f.Device
 	checkErr(c, db.InsertTxtMsg(msg))
 }
 
 func getRecentItems(c *gin.Context) {
-	items, err := db.GetRecentItems(15)
+	items, err := db.GetRecentItems(*recentItems)
 	if checkErr(c, err) {
 		return
 	}
@@ -336,7 +343,8 @@ func cliSetAlias(c *gin.Context) {
 }
 
 func getConfig(c *gin.Context) {
-	c.JSON(OK, db.Config.ToConfigForm())
+	config := db.GetConfig()
+	c.JSON(OK, config.ToConfigForm())
 }
 
 func updateConfig(c *gin.Context) {
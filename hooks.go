@@ -0,0 +1,268 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ahui2016/txt/model"
+	"github.com/ahui2016/txt/mydb"
+	"github.com/gin-gonic/gin"
+)
+
+// 请求内容的大小上限
+const hookBodyLimit = 64 * 1024
+
+// Slack 的请求时间与服务器时间最多相差多少，防止重放攻击。
+const slackMaxSkew = 5 * time.Minute
+
+const hookHelp = "Usage: send <message> | get [alias or index] | search <keyword>"
+
+// hookSecrets 是启用的 adapter 及其密钥 (来自 -hooks 参数)。
+var hookSecrets = map[string]string{}
+
+// hookResult 是一条命令的执行结果，由各 adapter 转换为相应的格式。
+type hookResult struct {
+	Text  string
+	Msg   *model.TxtMsg
+	Items []model.TxtMsg
+	Err   error
+	Code  int // 出错时的 HTTP 状态码 (零表示 400), 只用于 json adapter
+}
+
+// hookAdapter 把某种聊天工具的请求转换为命令，并以它期望的格式回复。
+type hookAdapter struct {
+	// verify 使用密钥验证请求，body 是原始的请求内容。
+	verify func(c *gin.Context, secret string, body []byte) error
+	// command 从请求内容中取出命令，例如 "send foo", "get email".
+	command func(body []byte) (string, error)
+	reply   func(c *gin.Context, result hookResult)
+}
+
+var hookAdapters = map[string]hookAdapter{
+	// Slack slash command (application/x-www-form-urlencoded), 使用 Signing Secret 验证。
+	"slack": {verify: verifySlack, command: formCommand, reply: slackReply},
+	// Mattermost slash command 或 outgoing webhook, 使用表单中的 token 验证。
+	"mattermost": {verify: verifyFormToken, command: formCommand, reply: slackReply},
+	// 通用 JSON: {"text": "send foo"}, 使用 X-Txt-Signature 验证 (与发出的 Webhook 相同)。
+	"json": {verify: verifySignature, command: jsonCommand, reply: jsonReply},
+}
+
+// parseHookSecrets 解析 -hooks 参数，例如 "slack=SECRET,json=SECRET".
+func parseHookSecrets(s string) (map[string]string, error) {
+	secrets := make(map[string]string)
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+		parts := strings.SplitN(item, "=", 2)
+		name := parts[0]
+		if len(parts) < 2 || parts[1] == "" {
+			return nil, fmt.Errorf("-hooks: missing secret for %q", name)
+		}
+		secret := parts[1]
+		if _, ok := hookAdapters[name]; !ok {
+			return nil, fmt.Errorf("-hooks: unknown adapter %q", name)
+		}
+		secrets[name] = secret
+	}
+	return secrets, nil
+}
+
+func verifySlack(c *gin.Context, secret string, body []byte) error {
+	ts := c.GetHeader("X-Slack-Request-Timestamp")
+	sec, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return errors.New("invalid timestamp")
+	}
+	if skew := time.Since(time.Unix(sec, 0)); skew > slackMaxSkew || skew < -slackMaxSkew {
+		return errors.New("the request is too old")
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte("v0:" + ts + ":"))
+	mac.Write(body)
+	want := "v0=" + hex.EncodeToString(mac.Sum(nil))
+	if !hmac.Equal([]byte(want), []byte(c.GetHeader("X-Slack-Signature"))) {
+		return errors.New("wrong signature")
+	}
+	return nil
+}
+
+func verifyFormToken(_ *gin.Context, secret string, body []byte) error {
+	form, err := url.ParseQuery(string(body))
+	if err != nil {
+		return err
+	}
+	if subtle.ConstantTimeCompare([]byte(form.Get("token")), []byte(secret)) != 1 {
+		return errors.New("wrong token")
+	}
+	return nil
+}
+
+func verifySignature(c *gin.Context, secret string, body []byte) error {
+	want := webhookSignature(secret, body)
+	if !hmac.Equal([]byte(want), []byte(c.GetHeader("X-Txt-Signature"))) {
+		return errors.New("wrong signature")
+	}
+	return nil
+}
+
+// formCommand 取出 slash command 的参数 (text), 如果是 outgoing webhook,
+// 则去掉开头的触发词 (trigger_word)。
+func formCommand(body []byte) (string, error) {
+	form, err := url.ParseQuery(string(body))
+	if err != nil {
+		return "", err
+	}
+	text := form.Get("text")
+	if trigger := form.Get("trigger_word"); trigger != "" {
+		text = strings.TrimPrefix(text, trigger)
+	}
+	return strings.TrimSpace(text), nil
+}
+
+func jsonCommand(body []byte) (string, error) {
+	var payload struct {
+		Text string `json:"text"`
+	}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(payload.Text), nil
+}
+
+// runHookCommand 执行命令，相当于 addTxtMsg, getByAliasIndex 与 searchHandler.
+func runHookCommand(c *gin.Context, command string) hookResult {
+	parts := strings.SplitN(command, " ", 2)
+	verb, arg := parts[0], ""
+	if len(parts) == 2 {
+		arg = strings.TrimSpace(parts[1])
+	}
+	switch strings.ToLower(verb) {
+	case "send", "add":
+		if arg == "" {
+			return hookResult{Err: errors.New(hookHelp)}
+		}
+		if *readonly {
+			return hookResult{Err: errors.New("Read-only Mode (只读模式) 不可修改数据。")}
+		}
+		if *demo && !demoCounter.add(c.ClientIP(), *demoMaxMsg) {
+			return hookResult{Err: errors.New("Demo Mode (演示模式) 已达到消息数量上限，请等待数据重置。")}
+		}
+		tm, err := db.NewTxtMsg(arg)
+		if err == nil {
+			err = db.InsertTxtMsg(tm)
+		}
+		if err != nil {
+			return hookResult{Err: err}
+		}
+		return hookResult{Text: "OK", Msg: &tm}
+	case "get":
+		if arg == "" {
+			arg = "t1"
+		}
+		tm, err := db.GetByAliasIndex(arg)
+		if errors.Is(err, mydb.ErrNoResult) {
+			return hookResult{Err: errors.New("not found: " + arg), Code: http.StatusNotFound}
+		}
+		if err != nil {
+			return hookResult{Err: err}
+		}
+		return hookResult{Text: tm.Msg, Msg: &tm}
+	case "search":
+		if arg == "" {
+			return hookResult{Err: errors.New(hookHelp)}
+		}
+		items, err := db.SearchTxtMsg(arg, nil)
+		if err != nil {
+			return hookResult{Err: err}
+		}
+		var lines []string
+		for _, tm := range items {
+			line := strings.SplitN(tm.Msg, "\n", 2)[0]
+			lines = append(lines, fmt.Sprintf("%s%d %s", strings.ToUpper(string(tm.Cat[:1])), tm.Index, line))
+		}
+		if len(lines) == 0 {
+			lines = append(lines, "not found: "+arg)
+		}
+		return hookResult{Text: strings.Join(lines, "\n"), Items: nonNilItems(items)}
+	}
+	return hookResult{Err: errors.New(hookHelp)}
+}
+
+// slackReply 回复 Slack 与 Mattermost, 出错时也返回 200 以便显示错误信息。
+func slackReply(c *gin.Context, result hookResult) {
+	text := result.Text
+	if result.Err != nil {
+		text = result.Err.Error()
+	} else if result.Msg != nil && text == result.Msg.Msg {
+		text = "```\n" + text + "\n```"
+	}
+	c.JSON(OK, gin.H{"response_type": "ephemeral", "text": text})
+}
+
+func jsonReply(c *gin.Context, result hookResult) {
+	if result.Err != nil {
+		code := result.Code
+		if code == 0 {
+			code = http.StatusBadRequest
+		}
+		c.JSON(code, gin.H{"ok": false, "text": result.Err.Error()})
+		return
+	}
+	body := gin.H{"ok": true, "text": result.Text}
+	if result.Msg != nil {
+		body["msg"] = result.Msg
+	}
+	if result.Items != nil {
+		body["items"] = result.Items
+	}
+	c.JSON(OK, body)
+}
+
+// hookTries 记录每个 IP 验证失败的次数。
+// 与 ipTryCount 分开，且不限制全部 IP 的次数，以免伪造的请求导致主密码与密钥被封锁。
+var hookTries = newTryCounter(false)
+
+// inboundHookHandler 处理聊天工具发来的命令，只有在 -hooks 中设置了密钥的 adapter 才可用。
+func inboundHookHandler(c *gin.Context) {
+	name := c.Param("adapter")
+	adapter, ok := hookAdapters[name]
+	secret := hookSecrets[name]
+	if !ok || secret == "" {
+		c.JSON(http.StatusNotFound, Text{"unknown adapter: " + name})
+		return
+	}
+	body, err := io.ReadAll(io.LimitReader(c.Request.Body, hookBodyLimit))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, Text{err.Error()})
+		return
+	}
+	ip := c.ClientIP()
+	if err := hookTries.check(ip); err != nil {
+		c.JSON(http.StatusForbidden, Text{err.Error()})
+		return
+	}
+	err = adapter.verify(c, secret, body)
+	hookTries.tried(ip, err == nil)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, Text{err.Error()})
+		return
+	}
+	command, err := adapter.command(body)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, Text{err.Error()})
+		return
+	}
+	adapter.reply(c, runHookCommand(c, command))
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// slackHeader 按 Slack 的方法对 body 签名。
+func slackHeader(secret string, ts time.Time, body string) http.Header {
+	stamp := strconv.FormatInt(ts.Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte("v0:" + stamp + ":" + body))
+	return http.Header{
+		"Content-Type":              {"application/x-www-form-urlencoded"},
+		"X-Slack-Request-Timestamp": {stamp},
+		"X-Slack-Signature":         {"v0=" + hex.EncodeToString(mac.Sum(nil))},
+	}
+}
+
+func jsonHeader(secret, body string) http.Header {
+	return http.Header{
+		"Content-Type":    {"application/json"},
+		"X-Txt-Signature": {webhookSignature(secret, []byte(body))},
+	}
+}
+
+func formBody(pairs ...string) string {
+	form := url.Values{}
+	for i := 0; i+1 < len(pairs); i += 2 {
+		form.Set(pairs[i], pairs[i+1])
+	}
+	return form.Encode()
+}
+
+func TestInboundHooks(t *testing.T) {
+	srv, _ := newTestServer(t)
+	insertTestMsgs(t, 2) // m0 (t2), m1 (t1)
+	if err := db.UpdateAlias("t1", "email"); err != nil {
+		t.Fatal(err)
+	}
+	hookSecrets = map[string]string{"slack": "s-secret", "mattermost": "m-token", "json": "j-secret"}
+	t.Cleanup(func() { hookSecrets = map[string]string{} })
+
+	now := time.Now()
+	slackGet := formBody("command", "/txt", "text", "get email")
+	form := http.Header{"Content-Type": {"application/x-www-form-urlencoded"}}
+
+	for _, tc := range []struct {
+		name    string
+		adapter string
+		body    string
+		header  http.Header
+		status  int
+		want    string // 回复中应包含的内容
+	}{
+		{"slack get", "slack", slackGet, slackHeader("s-secret", now, slackGet), 200, "```\\nm1\\n```"},
+		{"slack search", "slack", formBody("text", "search m0"),
+			slackHeader("s-secret", now, formBody("text", "search m0")), 200, "T2 m0"},
+		{"slack unknown command", "slack", formBody("text", "foo"),
+			slackHeader("s-secret", now, formBody("text", "foo")), 200, "Usage:"},
+		{"slack wrong secret", "slack", slackGet, slackHeader("other", now, slackGet), 401, "wrong signature"},
+		{"slack replay", "slack", slackGet, slackHeader("s-secret", now.Add(-10*time.Minute), slackGet), 401, "too old"},
+		{"slack no timestamp", "slack", slackGet, form, 401, "invalid timestamp"},
+
+		{"mattermost slash command", "mattermost", formBody("token", "m-token", "text", "get email"), form, 200, "m1"},
+		{"mattermost outgoing webhook", "mattermost",
+			formBody("token", "m-token", "trigger_word", "txt", "text", "txt get t2"), form, 200, "m0"},
+		{"mattermost not found", "mattermost", formBody("token", "m-token", "text", "get nope"), form, 200, "not found: nope"},
+		{"mattermost wrong token", "mattermost", formBody("token", "M-TOKEN", "text", "get email"), form, 401, "wrong token"},
+		{"mattermost no token", "mattermost", formBody("text", "get email"), form, 401, "wrong token"},
+
+		{"json get", "json", `{"text": "get email"}`, jsonHeader("j-secret", `{"text": "get email"}`), 200, `"ok":true`},
+		{"json get default", "json", `{"text": "get"}`, jsonHeader("j-secret", `{"text": "get"}`), 200, `"text":"m1"`},
+		{"json not found", "json", `{"text": "get nope"}`, jsonHeader("j-secret", `{"text": "get nope"}`), 404, `"ok":false`},
+		{"json empty send", "json", `{"text": "send"}`, jsonHeader("j-secret", `{"text": "send"}`), 400, "Usage:"},
+		{"json invalid body", "json", `text=get`, jsonHeader("j-secret", `text=get`), 400, ""},
+		{"json wrong signature", "json", `{"text": "get email"}`, jsonHeader("other", `{"text": "get email"}`), 401, "wrong signature"},
+		{"json tampered body", "json", `{"text": "get t2"}`, jsonHeader("j-secret", `{"text": "get email"}`), 401, "wrong signature"},
+
+		{"unknown adapter", "teams", `{}`, jsonHeader("j-secret", `{}`), 404, "unknown adapter"},
+	} {
+		hookTries.reset()
+		req, err := http.NewRequest("POST", srv.URL+"/hooks/"+tc.adapter, strings.NewReader(tc.body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header = tc.header
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != tc.status || !strings.Contains(string(body), tc.want) {
+			t.Errorf("%s: got %d %s; want %d containing %q", tc.name, resp.StatusCode, body, tc.status, tc.want)
+		}
+	}
+}
+
+// 未在 -hooks 中设置密钥的 adapter 不可用。
+func TestInboundHookDisabled(t *testing.T) {
+	srv, _ := newTestServer(t)
+	hookSecrets = map[string]string{"json": "j-secret"}
+	t.Cleanup(func() { hookSecrets = map[string]string{} })
+	body := formBody("token", "", "text", "get")
+	resp, err := http.Post(srv.URL+"/hooks/mattermost", "application/x-www-form-urlencoded", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("got %d; want 404", resp.StatusCode)
+	}
+}
+
+// 验证失败的次数只封锁 hooks, 不影响主密码与密钥。
+func TestInboundHookTries(t *testing.T) {
+	srv, key := newTestServer(t)
+	hookSecrets = map[string]string{"json": "j-secret"}
+	t.Cleanup(func() { hookSecrets = map[string]string{} })
+	body := `{"text": "get"}`
+	post := func(secret string) int {
+		t.Helper()
+		req, err := http.NewRequest("POST", srv.URL+"/hooks/json", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header = jsonHeader(secret, body)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	for i := 0; i < *passwordMaxTry; i++ {
+		if status := post("wrong"); status != http.StatusUnauthorized {
+			t.Fatalf("wrong secret %d: %d; want 401", i, status)
+		}
+	}
+	if status := post("j-secret"); status != http.StatusForbidden {
+		t.Errorf("after too many wrong secrets: %d; want 403", status)
+	}
+	if n := ipTryCount.get("127.0.0.1") + ipTryCount.get("all"); n > 0 {
+		t.Errorf("got %d tries of the main password; want none", n)
+	}
+	if status, _ := v2Do(t, "GET", srv.URL+"/v2/aliases", key, ""); status != OK {
+		t.Errorf("/v2/aliases: %d; want 200", status)
+	}
+}
+
+func TestParseHookSecrets(t *testing.T) {
+	for _, tc := range []struct {
+		in   string
+		want int // 启用的 adapter 数量, -1 表示应该出错
+	}{
+		{"", 0},
+		{"slack=a, json=b,", 2},
+		{"json=a=b", 1},
+		{"slack", -1},
+		{"slack=", -1},
+		{"teams=a", -1},
+	} {
+		secrets, err := parseHookSecrets(tc.in)
+		if tc.want < 0 {
+			if err == nil {
+				t.Errorf("parseHookSecrets(%q): want an error", tc.in)
+			}
+			continue
+		}
+		if err != nil || len(secrets) != tc.want {
+			t.Errorf("parseHookSecrets(%q) = %v, %v; want %d adapters", tc.in, secrets, err, tc.want)
+		}
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"time"
 
 	"github.com/ahui2016/txt/mydb"
 	"github.com/ahui2016/txt/util"
@@ -17,19 +18,74 @@ const (
 )
 
 var (
-	db       = new(mydb.DB)
+	db       mydb.Store
 	addr     = flag.String("addr", "127.0.0.1:8000", "Local IP address. Example: 127.0.0.1:8000")
 	debug    = flag.Bool("debug", false, "Switch to debug mode.")
 	demo     = flag.Bool("demo", false, "Set this flag for demo.")
 	dbFolder = flag.String("db", "", "Specify a folder for the database.")
+	memory   = flag.Bool("memory", false, "Use an in-memory database (all data is lost on exit).")
+	readonly = flag.Bool("readonly", false, "Open the database read-only and reject all modifications.")
+
+	configFile     = flag.String("config", "", "Config file (YAML). Default: $TXT_CONFIG or config.yaml in the config folder.")
+	trustedProxies = flag.String("trusted-proxies", "127.0.0.1", "Comma-separated IPs or CIDRs of trusted reverse proxies (empty: trust none).")
+	sessionMaxAge  = flag.Duration("session-max-age", 30*24*time.Hour, "How long a web sign-in lasts.")
+	passwordMaxTry = flag.Int("password-max-try", 5, "Max wrong passwords per IP before it is blocked.")
+	allIP_MaxTry   = flag.Int("all-ip-max-try", 100, "Max wrong passwords of all IPs before sign-in is blocked.")
+	recentItems    = flag.Int("recent-items", 15, "How many temporary (and permanent) messages the web page shows at first.")
+
+	peer         = flag.String("peer", "", "URL of another txt server to replicate from. Example: https://example.com")
+	peerKey      = flag.String("peer-key", "", "The secret key of the peer server.")
+	peerInterval = flag.Duration("peer-interval", 30*time.Second, "How often to pull changes from the peer.")
+
+	changelogKeep = flag.Int("changelog-keep", 10000, "How many recent changes to keep in the change log (0: keep all).")
+
+	demoReset  = flag.Duration("demo-reset", time.Hour, "How often the demo database is reset. Example: 30m")
+	demoRate   = flag.Int("demo-rate", 60, "Max requests per minute per IP in demo mode.")
+	demoMaxMsg = flag.Int("demo-max-msg", 20, "Max new messages per IP between two resets in demo mode.")
+
+	shutdownTimeout = flag.Duration("shutdown-timeout", 10*time.Second, "On SIGINT/SIGTERM, how long to wait for in-flight requests before closing the database.")
+	shutdownDelay   = flag.Duration("shutdown-delay", 0, "On SIGINT/SIGTERM, how long /ready reports 503 before the server stops accepting requests.")
+
+	tlsCert       = flag.String("tls-cert", "", "TLS certificate file (PEM), requires -tls-key. Reloaded on SIGHUP.")
+	tlsKey        = flag.String("tls-key", "", "TLS private key file (PEM).")
+	tlsSelfSigned = flag.Bool("tls-self-signed", false, "Serve HTTPS with a self-signed certificate (generated once and saved in the config folder).")
+	tlsHosts      = flag.String("tls-hosts", "", "Extra host names or IPs for the self-signed certificate. Example: example.lan,192.168.1.2")
+
+	sshAddr = flag.String("ssh-addr", "", "Enable the SSH interface on this address. Example: 0.0.0.0:2222")
+
+	smtpAddr   = flag.String("smtp-addr", "", "Enable the SMTP listener on this address. Example: 127.0.0.1:2525")
+	smtpRcpt   = flag.String("smtp-rcpt", "txt@localhost", "The SMTP recipient address, the secret is added to its local part.")
+	smtpSecret = flag.String("smtp-secret", "", "The secret in the SMTP recipient address, e.g. txt+SECRET@localhost")
+
+	hooks = flag.String("hooks", "", "Enable inbound webhook adapters with their secrets. Example: slack=SECRET,json=SECRET")
 )
 
-func init() {
-	flag.Parse()
+// openDB 根据命令行参数打开数据库。
+// 注意：只有启动服务器时才打开数据库，执行客户端命令时不打开。
+func openDB() {
+	if *demo {
+		fmt.Println("[Database] in-memory (demo)")
+		memDB := mydb.NewMemDB()
+		seedDemo(memDB)
+		db = memDB
+		return
+	}
+	if *memory {
+		fmt.Println("[Database] in-memory")
+		db = mydb.NewMemDB()
+		return
+	}
 	dbPath := getDBPath()
 	fmt.Println("[Database]", dbPath)
 
-	util.Panic(db.Open(dbPath))
+	boltDB := new(mydb.DB)
+	if *readonly {
+		fmt.Println("[Read-only Mode]")
+		util.Panic(boltDB.OpenReadOnly(dbPath))
+	} else {
+		util.Panic(boltDB.Open(dbPath))
+	}
+	db = boltDB
 }
 
 func getDBPath() string {
@@ -41,9 +97,14 @@ func getDBPath() string {
 		}
 		return filepath.Join(folder, dbFileName)
 	}
+	return filepath.Join(appConfigFolder(), dbFileName)
+}
+
+// appConfigFolder 返回本软件的配置文件夹，如果不存在则自动创建。
+func appConfigFolder() string {
 	userConfigDir, err := os.UserConfigDir()
 	util.Panic(err)
 	configFolder := filepath.Join(userConfigDir, AppConfigFolder)
 	util.Panic(os.MkdirAll(configFolder, 0740))
-	return filepath.Join(configFolder, dbFileName)
+	return configFolder
 }
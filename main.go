@@ -1,10 +1,15 @@
 package main
 
 import (
+	"context"
 	"embed"
+	"flag"
 	"log"
 	"net/http"
+	"os"
+	"sync"
 
+	"github.com/ahui2016/txt/mydb"
 	"github.com/gin-contrib/sessions"
 	"github.com/gin-contrib/sessions/cookie"
 	"github.com/gin-gonic/gin"
@@ -17,7 +22,71 @@ var staticFiles embed.FS
 var staticJS embed.FS
 
 func main() {
-	defer db.DB.Close()
+	// 第一个参数是子命令时，作为客户端运行。
+	if len(os.Args) > 1 && isClientCommand(os.Args[1]) {
+		os.Exit(runClient(os.Args[1], os.Args[2:]))
+	}
+
+	flag.Parse()
+	if _, err := applyConfig(flag.CommandLine); err != nil {
+		log.Fatal(err)
+	}
+	secrets, err := parseHookSecrets(*hooks)
+	if err != nil {
+		log.Fatal(err)
+	}
+	hookSecrets = secrets
+	var recipient string
+	if *smtpAddr != "" {
+		if *readonly {
+			log.Fatal("the SMTP listener cannot be used in read-only mode")
+		}
+		if recipient, err = smtpRecipient(*smtpRcpt, *smtpSecret); err != nil {
+			log.Fatal(err)
+		}
+	}
+	tlsCfg, err := tlsConfig()
+	if err != nil {
+		log.Fatal(err)
+	}
+	openDB()
+	defer db.Close()
+	initPassword()
+
+	// ctx 在关闭服务器时结束，用于停止后台任务与 SSH, SMTP (见 serve)。
+	ctx, stop := context.WithCancel(context.Background())
+	defer stop()
+	var workers sync.WaitGroup
+	startWorker := func(f func()) {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			f()
+		}()
+	}
+	if memDB, ok := db.(*mydb.MemDB); ok && *demo {
+		startWorker(func() { demoResetLoop(ctx, memDB) })
+		startWorker(func() { rateResetLoop(ctx) })
+	}
+	if !*readonly {
+		startWorker(func() { replicateLoop(ctx) })
+		startWorker(func() { webhookLoop(ctx) })
+		startWorker(func() { trimChangesLoop(ctx) })
+	}
+	if *sshAddr != "" {
+		startWorker(func() {
+			if err := sshListenAndServe(ctx, *sshAddr); err != nil {
+				log.Fatal(err)
+			}
+		})
+	}
+	if *smtpAddr != "" {
+		startWorker(func() {
+			if err := smtpListenAndServe(ctx, *smtpAddr, recipient); err != nil {
+				log.Fatal(err)
+			}
+		})
+	}
 
 	if *debug {
 		gin.SetMode(gin.DebugMode)
@@ -25,14 +94,33 @@ func main() {
 		gin.SetMode(gin.ReleaseMode)
 		log.Print("[Listen and serve] ", *addr)
 	}
+	r, err := newRouter()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	srv := &http.Server{
+		Addr:      *addr,
+		Handler:   r,
+		TLSConfig: tlsCfg,
+	}
+	if err := serve(srv, stop, &workers); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// newRouter 注册全部路由。数据库 (db) 必须已经打开。
+func newRouter() (*gin.Engine, error) {
 	r := gin.New()
-	r.Use(gin.Recovery())
+	r.Use(gin.Recovery(), TrackInFlight())
 	if *debug {
 		r.Use(gin.Logger())
 	}
 
 	// 必须正确设置此项才能获取真实IP
-	r.SetTrustedProxies([]string{"127.0.0.1"})
+	if err := r.SetTrustedProxies(splitList(*trustedProxies)); err != nil {
+		return nil, err
+	}
 
 	sessionStore := cookie.NewStore(generateRandomKey())
 	r.Use(sessions.Sessions(sessionName, sessionStore))
@@ -56,11 +144,19 @@ func main() {
 		c.FileFromFS("/favicon.ico", EmbedFolder(staticFiles, "static"))
 	})
 
+	spec, err := openAPISpec()
+	if err != nil {
+		return nil, err
+	}
+	r.GET("/openapi.json", openAPIHandler(spec))
+
+	r.GET("/ready", readyHandler)
+
 	r.GET("/", func(c *gin.Context) {
 		c.Redirect(http.StatusFound, "/public/index.html")
 	})
 
-	auth := r.Group("/auth", Sleep())
+	auth := r.Group("/auth", CheckSetup(), Sleep(), DemoRateLimit())
 	{
 		auth.GET("/is-signed-in", func(c *gin.Context) {
 			c.JSON(OK, isSignedIn(c))
@@ -68,38 +164,94 @@ func main() {
 		auth.POST("/sign-in", signInHandler)
 		auth.GET("/sign-out", signOutHandler)
 		auth.POST("/get-current-key", getCurrentKey)
-		auth.POST("/gen-new-key", generateKeyHandler)
-		auth.POST("/change-pwd", changePwdHandler)
+		auth.POST("/gen-new-key", CheckWritable(), generateKeyHandler)
+		auth.POST("/change-pwd", CheckWritable(), changePwdHandler)
 	}
 
-	api := r.Group("/api", Sleep(), CheckSignIn())
+	api := r.Group("/api", CheckSetup(), Sleep(), DemoRateLimit(), CheckSignIn())
 	{
-		api.POST("/add", addTxtMsg)
+		api.POST("/add", CheckWritable(), DemoMsgLimit(), addTxtMsg)
 		api.GET("/recent-items", getRecentItems)
-		api.POST("/toggle-category", toggleCatHandler)
-		api.POST("/delete", deleteHandler)
+		api.POST("/toggle-category", CheckWritable(), toggleCatHandler)
+		api.POST("/delete", CheckWritable(), deleteHandler)
 		api.POST("/get-by-id", getByID)
-		api.POST("/edit", editHandler)
+		api.POST("/edit", CheckWritable(), editHandler)
 		api.GET("/get-config", getConfig)
-		api.POST("/update-config", updateConfig)
+		api.POST("/update-config", CheckWritable(), updateConfig)
 		api.POST("/get-more-items", getMoreItems)
 		api.GET("/get-all-aliases", getAliasesHandler)
 		api.POST("/search", searchHandler)
+		api.GET("/events", eventsHandler)
 	}
 
-	cli := r.Group("/cli", Sleep(), CliCheckKey())
+	cli := r.Group("/cli", CheckSetup(), Sleep(), DemoRateLimit(), CliCheckKey())
 	{
-		cli.POST("/add", addTxtMsg)
-		cli.POST("/toggle-category", cliToggleCat)
-		cli.POST("/delete", cliDeleteHandler)
+		cli.POST("/add", CheckWritable(), DemoMsgLimit(), addTxtMsg)
+		cli.POST("/toggle-category", CheckWritable(), cliToggleCat)
+		cli.POST("/delete", CheckWritable(), cliDeleteHandler)
 		cli.POST("/get-by-a-or-i", getByAliasIndex)
-		cli.POST("/set-alias", cliSetAlias)
+		cli.GET("/get-by-a-or-i", getByAliasIndex)
+		cli.POST("/set-alias", CheckWritable(), cliSetAlias)
 		cli.POST("/get-more-items", cliGetMoreItems)
+		cli.GET("/get-more-items", cliGetMoreItems)
 		cli.POST("/get-all-aliases", getAliasesHandler)
+		cli.GET("/get-all-aliases", getAliasesHandler)
 		cli.POST("/search", searchHandler)
+		cli.GET("/search", searchHandler)
+		cli.POST("/replicate", cliReplicateHandler)
+		cli.GET("/changes", cliChangesHandler)
+		cli.GET("/events", eventsHandler)
+		cli.POST("/register-device", CheckWritable(), registerDeviceHandler)
+		cli.POST("/get-devices", getDevicesHandler)
+		cli.GET("/get-devices", getDevicesHandler)
+		cli.POST("/delete-device", CheckWritable(), deleteDeviceHandler)
+		cli.GET("/clipboard", clipboardHandler)
+		cli.GET("/wait", cliWaitHandler)
+		cli.POST("/share", CheckWritable(), createShareHandler)
+		cli.POST("/get-shares", getSharesHandler)
+		cli.GET("/get-shares", getSharesHandler)
+		cli.POST("/revoke-share", CheckWritable(), revokeShareHandler)
+		cli.POST("/add-webhook", CheckWritable(), addWebhookHandler)
+		cli.POST("/get-webhooks", getWebhooksHandler)
+		cli.GET("/get-webhooks", getWebhooksHandler)
+		cli.POST("/delete-webhook", CheckWritable(), deleteWebhookHandler)
+		cli.POST("/get-deliveries", getDeliveriesHandler)
+		cli.GET("/get-deliveries", getDeliveriesHandler)
+		cli.POST("/add-ssh-key", CheckWritable(), addSSHKeyHandler)
+		cli.POST("/get-ssh-keys", getSSHKeysHandler)
+		cli.GET("/get-ssh-keys", getSSHKeysHandler)
+		cli.POST("/delete-ssh-key", CheckWritable(), deleteSSHKeyHandler)
+		cli.POST("/qr", qrHandler)
+		cli.GET("/qr", qrHandler)
 	}
 
-	if err := r.Run(*addr); err != nil {
-		log.Fatal(err)
+	// v2 使用 JSON 与 HTTP 状态码，错误响应包含固定的错误代码 (见 v2.go)。
+	v2 := r.Group("/v2", Sleep(), V2DemoRateLimit(), V2CheckKey())
+	{
+		v2.GET("/messages", v2ListMessages)
+		v2.POST("/messages", V2CheckWritable(), V2DemoMsgLimit(), v2CreateMessage)
+		v2.GET("/messages/:id", v2GetMessage)
+		v2.PATCH("/messages/:id", V2CheckWritable(), v2UpdateMessage)
+		v2.DELETE("/messages/:id", V2CheckWritable(), v2DeleteMessage)
+		v2.GET("/aliases", v2ListAliases)
+		v2.GET("/aliases/:name", v2GetAlias)
 	}
+
+	// 只返回消息内容，方便在 shell 里使用
+	r.GET("/raw/:alias_or_index", CheckSetup(), Sleep(), DemoRateLimit(), CliCheckKey(), rawHandler)
+
+	// Atom feed, 密钥的传递方式与 /cli 相同 (阅读器通常只能使用查询参数 password)
+	r.GET("/feed", CheckSetup(), Sleep(), DemoRateLimit(), CliCheckKey(), feedHandler)
+
+	// 分享链接，不需要密钥
+	share := r.Group("/s", Sleep(), DemoRateLimit())
+	{
+		share.GET("/:token", sharePageHandler)
+		share.POST("/:token", sharePageHandler)
+		share.GET("/:token/raw", shareRawHandler)
+	}
+
+	// 聊天工具的 slash command 等，使用各 adapter 自己的密钥验证。
+	r.POST("/hooks/:adapter", CheckSetup(), DemoRateLimit(), inboundHookHandler)
+	return r, nil
 }
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ahui2016/txt/model"
+	"github.com/ahui2016/txt/mydb"
+	"github.com/gin-gonic/gin"
+)
+
+const testPassword = "test-password"
+
+// newTestServer 使用一个新的 MemDB 启动全部路由，返回服务器与当前的密钥。
+// 注意：db 与 ipTryCount 是全局变量，因此使用本函数的测试不可并行。
+func newTestServer(t *testing.T) (srv *httptest.Server, key string) {
+	t.Helper()
+	memDB := newTestDB(t)
+	r, err := newRouter()
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv = httptest.NewServer(r)
+	t.Cleanup(srv.Close)
+	return srv, memDB.GetConfig().Key
+}
+
+// newTestDB 把 db 设为一个已设置主密码的 MemDB, 并清空密码错误计数。
+func newTestDB(t *testing.T) *mydb.MemDB {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	memDB := mydb.NewMemDB()
+	if err := memDB.InitPassword(testPassword); err != nil {
+		t.Fatal(err)
+	}
+	db = memDB
+	ipTryCount.reset()
+	hookTries.reset()
+	smtpTries.reset()
+	shareTries.count = make(map[string]int)
+	return memDB
+}
+
+// insertTestMsgs 直接插入 n 条暂存消息 (不使用 NewTxtMsg, 以免每条消息暂停一秒)。
+func insertTestMsgs(t *testing.T, n int) {
+	t.Helper()
+	base := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < n; i++ {
+		id, err := model.DateIDAt(base.Add(time.Duration(i)*time.Second), mydb.BeijingTime)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := db.InsertTxtMsg(model.TxtMsg{ID: id, Msg: fmt.Sprint("m", i), Cat: model.CatTemp}); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
@@ -25,6 +25,7 @@ type TxtMsg struct {
 	Msg    string   // 消息内容
 	Cat    Category // 类型（比如暂存、永久）
 	Index  int      // 流水号，每当插入或删除条目时，需要更新全部条目的流水号
+	Device string   // 发送该消息的设备名称 (可以为空)
 }
 
 func NewTxtMsg(msg, offset string) (tm TxtMsg, err error) {
@@ -69,6 +70,7 @@ type Config struct {
 	TempLimit      int    // 暂存消息条数上限（永久消息不设上限）
 	EveryPageLimit int    // 每页最多列出多少条消息
 	TimeOffset     string // "+8" 表示北京时间, "-5" 表示纽约时间, 依此类推。
+	NodeID         string // 本服务器的唯一标识，用于服务器之间的同步
 }
 
 func (config *Config) ToConfigForm() ConfigForm {
@@ -87,7 +89,11 @@ func (config *Config) ToConfigForm() ConfigForm {
 // 返回的 id 格式是 "2006-01-02_150405", 由于有可能用于 html 元素的 id, 因此不含空格与冒号。
 func DateID(offset string) (string, error) {
 	time.Sleep(time.Second)
+	return DateIDAt(time.Now(), offset)
+}
 
+// DateIDAt 与 DateID 一样，但使用指定的时间 t, 并且不暂停。
+func DateIDAt(t time.Time, offset string) (string, error) {
 	timezone, err := time.ParseDuration(offset + "h")
 	if err != nil {
 		return "", err
@@ -95,7 +101,7 @@ func DateID(offset string) (string, error) {
 
 	// utcFormat 大概长这个样子 => "2022-02-14_214208+00:00"
 	// 由于 utcFormat 包含了时区 +00:00, 因此 dt 的时区就是 UTC
-	utcFormat := time.Now().UTC().Format("2006-01-02_150405-07:00")
+	utcFormat := t.UTC().Format("2006-01-02_150405-07:00")
 	dt, err := time.Parse("2006-01-02_150405-07:00", utcFormat)
 	if err != nil {
 		return "", err
@@ -105,8 +111,156 @@ func DateID(offset string) (string, error) {
 	return dt.Add(timezone).Format("2006-01-02_150405"), nil
 }
 
+// DateIDTime 是 DateIDAt 的逆运算，把 id 还原为时间 (精确到秒)。
+func DateIDTime(id, offset string) (time.Time, error) {
+	timezone, err := time.ParseDuration(offset + "h")
+	if err != nil {
+		return time.Time{}, err
+	}
+	loc := time.FixedZone("", int(timezone.Seconds()))
+	return time.ParseInLocation("2006-01-02_150405", id, loc)
+}
+
+// Device 是一台登记过的设备，用于剪贴板同步。
+type Device struct {
+	Name     string
+	Token    string   `json:"-"` // 连接剪贴板同步通道时用于验证身份，每次登记都会重新生成
+	Created  int64    // 登记时间 (timestamp)
+	SkipSelf bool     // 是否不接收本设备发送的消息
+	Mute     []string // 不接收这些设备发送的消息
+}
+
+// Wants 判断本设备是否应该接收 tm.
+func (d Device) Wants(tm TxtMsg) bool {
+	if tm.Device == d.Name && d.SkipSelf {
+		return false
+	}
+	for _, name := range d.Mute {
+		if tm.Device == name {
+			return false
+		}
+	}
+	return true
+}
+
+type ChangeOp string
+
+const (
+	OpInsert ChangeOp = "insert"
+	OpEdit   ChangeOp = "edit"
+	OpAlias  ChangeOp = "alias"
+	OpToggle ChangeOp = "toggle"
+	OpDelete ChangeOp = "delete"
+)
+
+// Version 表示一条消息最后一次被修改的时间与来源，用于解决同步冲突。
+type Version struct {
+	Time      int64  // 修改时间 (timestamp)
+	Origin    string // 最初产生该修改的服务器的 Config.NodeID
+	OriginSeq uint64 // 该修改在 Origin 服务器上的流水号
+}
+
+// After 判断 v 是否比 other 更新，依次比较 Time, Origin, OriginSeq,
+// 因此任何两台服务器对同一对 Version 总会得出相同的结论。
+func (v Version) After(other Version) bool {
+	if v.Time != other.Time {
+		return v.Time > other.Time
+	}
+	if v.Origin != other.Origin {
+		return v.Origin > other.Origin
+	}
+	return v.OriginSeq > other.OriginSeq
+}
+
+// Change 是一条修改记录 (change log), 每次插入、编辑、转换、修改别名、删除
+// 消息都会产生一条 Change.
+type Change struct {
+	Seq      uint64   // 本地流水号，单调递增
+	Recorded int64    // 写入本地 change log 的时间 (timestamp), 与 Seq 一样只对本服务器有意义
+	Op       ChangeOp // 修改类型
+	ID       string   // TxtMsg.ID, 如果是 OpToggle 则是转换后的新 ID
+	OldID    string   // 仅用于 OpToggle, 转换前的 ID
+	Msg      TxtMsg   // 修改后的消息 (OpDelete 时是被删除的消息)
+	Version
+}
+
 type EditForm struct {
 	ID    string `form:"id" binding:"required"`
 	Alias string `form:"alias"`
 	Msg   string `form:"msg" binding:"required"`
 }
+
+// Share 是一条消息的公开分享链接，不需要密钥即可查看 (/s/:token)。
+// 分享链接只保存在本服务器，不参与同步。
+type Share struct {
+	Token       string
+	MsgID       string
+	Created     int64  // 创建时间 (timestamp)
+	Expires     int64  // 过期时间 (timestamp), 零表示永不过期
+	MaxViews    int    // 最多可查看多少次，零表示不限
+	Views       int    // 已查看次数
+	HasPassword bool   // 是否需要密码
+	Password    string `json:"-"` // 密码的 bcrypt 哈希
+}
+
+// Expired 判断分享链接在 now 时是否已失效 (过期或查看次数已满)。
+func (s Share) Expired(now int64) bool {
+	if s.Expires > 0 && now > s.Expires {
+		return true
+	}
+	return s.MaxViews > 0 && s.Views >= s.MaxViews
+}
+
+// Webhook 是一个接收修改通知的网址，每当发生 Events 中的修改时，
+// 服务器会向 URL 发送一个 POST 请求 (JSON), 并用 Secret 签名。
+type Webhook struct {
+	ID      string
+	URL     string
+	Events  []ChangeOp // 空表示全部
+	Secret  string     `json:"-"` // 用于 HMAC-SHA256 签名，只在添加时返回一次
+	Created int64
+}
+
+// Wants 判断该 Webhook 是否接收 op 类型的修改。
+func (w Webhook) Wants(op ChangeOp) bool {
+	if len(w.Events) == 0 {
+		return true
+	}
+	for _, event := range w.Events {
+		if event == op {
+			return true
+		}
+	}
+	return false
+}
+
+type DeliveryStatus string
+
+const (
+	DeliveryPending DeliveryStatus = "pending"
+	DeliveryOK      DeliveryStatus = "ok"
+	DeliveryFailed  DeliveryStatus = "failed" // 重试次数已满
+)
+
+// Delivery 是一次 Webhook 通知，失败时会稍后重试。
+type Delivery struct {
+	ID        uint64
+	HookID    string
+	Change    Change
+	Status    DeliveryStatus
+	Attempts  int    // 已尝试次数
+	NextTry   int64  // 下次尝试的时间 (timestamp)
+	LastCode  int    // 最后一次的 HTTP 状态码
+	LastError string // 最后一次的错误信息
+	Updated   int64
+}
+
+// SSHKey 是一个登记过的 SSH 公钥，用于登录内置的 SSH 服务器。
+// 登录时还要检查当前的密钥是否与 KeyFingerprint 一致，因此更换密钥后需要重新登记公钥。
+type SSHKey struct {
+	Fingerprint    string // SHA256 指纹，例如 "SHA256:..."
+	Name           string // 备注，例如 "laptop"
+	PublicKey      string // authorized_keys 格式
+	KeyFingerprint string `json:"-"` // 登记时使用的日常操作密钥的指纹 (不保存密钥本身)
+	Created        int64  // 登记时间 (timestamp)
+}
@@ -0,0 +1,128 @@
+package mydb
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/ahui2016/txt/model"
+	"github.com/ahui2016/txt/util"
+	"github.com/vmihailenco/msgpack/v5"
+	bolt "go.etcd.io/bbolt"
+)
+
+type Device = model.Device
+
+func checkDeviceName(name string) error {
+	if name == "" {
+		return fmt.Errorf("the device name is empty")
+	}
+	if len(name) > 64 {
+		return fmt.Errorf("the device name is too long")
+	}
+	return nil
+}
+
+// PutDevice 登记或更新一台设备，更新时保留原来的登记时间。
+func (db *DB) PutDevice(device Device) error {
+	if err := checkDeviceName(device.Name); err != nil {
+		return err
+	}
+	return db.DB.Update(func(tx *bolt.Tx) error {
+		old, err := txGetDevice(tx, device.Name)
+		if err != nil && err != ErrNoResult {
+			return err
+		}
+		device.Created = old.Created
+		if err == ErrNoResult {
+			device.Created = util.TimeNow()
+		}
+		return txPutObject(tx, device_bucket, device.Name, device)
+	})
+}
+
+func txGetDevice(tx *bolt.Tx, name string) (device Device, err error) {
+	data, err := txGetBytes(tx, device_bucket, name)
+	if err != nil {
+		return
+	}
+	err = msgpack.Unmarshal(data, &device)
+	return
+}
+
+func (db *DB) GetDevice(name string) (device Device, err error) {
+	err = db.DB.View(func(tx *bolt.Tx) error {
+		device, err = txGetDevice(tx, name)
+		return err
+	})
+	return
+}
+
+func (db *DB) GetDevices() (devices []Device, err error) {
+	err = db.DB.View(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(device_bucket)).ForEach(func(_, v []byte) error {
+			var device Device
+			if err := msgpack.Unmarshal(v, &device); err != nil {
+				return err
+			}
+			devices = append(devices, device)
+			return nil
+		})
+	})
+	return
+}
+
+func (db *DB) DeleteDevice(name string) error {
+	return db.DB.Update(func(tx *bolt.Tx) error {
+		if _, err := txGetDevice(tx, name); err != nil {
+			return err
+		}
+		return tx.Bucket([]byte(device_bucket)).Delete([]byte(name))
+	})
+}
+
+func (db *MemDB) PutDevice(device Device) error {
+	if err := checkDeviceName(device.Name); err != nil {
+		return err
+	}
+	db.Lock()
+	defer db.Unlock()
+	if old, ok := db.devices[device.Name]; ok {
+		device.Created = old.Created
+	} else {
+		device.Created = util.TimeNow()
+	}
+	db.devices[device.Name] = device
+	return nil
+}
+
+func (db *MemDB) GetDevice(name string) (Device, error) {
+	db.RLock()
+	defer db.RUnlock()
+	device, ok := db.devices[name]
+	if !ok {
+		return device, ErrNoResult
+	}
+	return device, nil
+}
+
+func (db *MemDB) GetDevices() (devices []Device, err error) {
+	db.RLock()
+	defer db.RUnlock()
+	for _, device := range db.devices {
+		devices = append(devices, device)
+	}
+	sort.Slice(devices, func(i, j int) bool {
+		return devices[i].Name < devices[j].Name
+	})
+	return
+}
+
+func (db *MemDB) DeleteDevice(name string) error {
+	db.Lock()
+	defer db.Unlock()
+	if _, ok := db.devices[name]; !ok {
+		return ErrNoResult
+	}
+	delete(db.devices, name)
+	return nil
+}
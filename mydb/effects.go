@@ -0,0 +1,48 @@
+package mydb
+
+import (
+	"github.com/ahui2016/txt/model"
+	bolt "go.etcd.io/bbolt"
+)
+
+// changeEffects 是一条修改记录对消息以外的数据产生的影响。
+// 这些数据不参与复制，因此不放在 replicaTx 中，而是由调用者在记录修改的
+// 同一个事务 (或同一次写锁) 中通过 afterChange 处理。
+type changeEffects interface {
+	moveShares(oldID, newID string) error // 消息转换类型后 ID 会改变
+	deleteShares(msgID string) error
+	queueDeliveries(ch Change) error // 为每个相关的 Webhook 添加一个 Delivery
+}
+
+// afterChange 使分享链接跟随消息，并为 Webhook 添加 Delivery.
+// 本地产生的修改与来自其他服务器的修改都要处理。
+func afterChange(e changeEffects, ch Change) error {
+	switch ch.Op {
+	case model.OpToggle:
+		if err := e.moveShares(ch.OldID, ch.ID); err != nil {
+			return err
+		}
+	case model.OpDelete:
+		if err := e.deleteShares(ch.ID); err != nil {
+			return err
+		}
+	}
+	return e.queueDeliveries(ch)
+}
+
+// boltEffects 是 changeEffects 的 bolt 实现。
+type boltEffects struct {
+	tx *bolt.Tx
+}
+
+func (e boltEffects) moveShares(oldID, newID string) error {
+	return txMoveShares(e.tx, oldID, newID)
+}
+
+func (e boltEffects) deleteShares(msgID string) error {
+	return txDeleteShares(e.tx, msgID)
+}
+
+func (e boltEffects) queueDeliveries(ch Change) error {
+	return txQueueDeliveries(e.tx, ch)
+}
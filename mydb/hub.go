@@ -0,0 +1,51 @@
+package mydb
+
+import "sync"
+
+// 每个订阅者最多可积压多少条未读取的修改记录
+const subscriberBuffer = 64
+
+// changeHub 是一个简单的进程内 pub/sub, 每当修改记录 commit 之后，
+// 就会发送给全部订阅者。
+type changeHub struct {
+	sync.Mutex
+	subs map[chan Change]struct{}
+}
+
+func newChangeHub() *changeHub {
+	return &changeHub{subs: make(map[chan Change]struct{})}
+}
+
+// subscribe 返回一个接收修改记录的 channel 以及取消订阅的函数。
+// 如果订阅者处理太慢导致积压超过上限，channel 会被关闭，
+// 此时订阅者应使用 ChangesSince 补回遗漏的修改记录后重新订阅。
+func (h *changeHub) subscribe() (<-chan Change, func()) {
+	ch := make(chan Change, subscriberBuffer)
+	h.Lock()
+	h.subs[ch] = struct{}{}
+	h.Unlock()
+
+	cancel := func() {
+		h.Lock()
+		defer h.Unlock()
+		if _, ok := h.subs[ch]; ok {
+			delete(h.subs, ch)
+			close(ch)
+		}
+	}
+	return ch, cancel
+}
+
+// publish 不会阻塞。
+func (h *changeHub) publish(change Change) {
+	h.Lock()
+	defer h.Unlock()
+	for ch := range h.subs {
+		select {
+		case ch <- change:
+		default:
+			delete(h.subs, ch)
+			close(ch)
+		}
+	}
+}
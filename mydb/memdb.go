@@ -0,0 +1,642 @@
+package mydb
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/ahui2016/txt/model"
+	"github.com/ahui2016/txt/util"
+)
+
+// MemDB 是 Store 的纯内存实现，程序退出后数据即消失。
+// 适用于测试与演示，其行为应与 DB 保持一致。
+type MemDB struct {
+	sync.RWMutex
+	config Config
+	temp   []TxtMsg          // 按 ID 从旧到新排列
+	perm   []TxtMsg          // 按 ID 从旧到新排列
+	alias  map[string]string // alias => TxtMsg.ID
+
+	seq      uint64             // change log 的最新流水号
+	changes  []Change           // change log, 按 Seq 从旧到新排列
+	trimmed  uint64             // 已删除的修改记录的最大流水号 (见 TrimChanges)
+	versions map[string]Version // TxtMsg.ID => Version
+	peers    map[string]uint64  // peer => cursor
+	hub      *changeHub
+
+	devices map[string]Device
+	shares  map[string]Share // Share.Token => Share
+
+	webhooks    map[string]Webhook
+	deliveries  []Delivery // 按 ID 从旧到新排列
+	deliverySeq uint64
+
+	sshKeys map[string]SSHKey // SSHKey.Fingerprint => SSHKey
+}
+
+func NewMemDB() *MemDB {
+	return &MemDB{
+		config:   newConfig(),
+		alias:    make(map[string]string),
+		versions: make(map[string]Version),
+		peers:    make(map[string]uint64),
+		hub:      newChangeHub(),
+		devices:  make(map[string]Device),
+		shares:   make(map[string]Share),
+		webhooks: make(map[string]Webhook),
+		sshKeys:  make(map[string]SSHKey),
+	}
+}
+
+func (db *MemDB) Subscribe() (<-chan Change, func()) {
+	return db.hub.subscribe()
+}
+
+// recordChange 记录一条本地产生的修改，调用前必须已取得写锁。
+func (db *MemDB) recordChange(ch Change) error {
+	ch, err := recordChange(memReplica{db}, ch)
+	if err != nil {
+		return err
+	}
+	return afterChange(db, ch)
+}
+
+func (db *MemDB) Close() error {
+	return nil
+}
+
+func (db *MemDB) GetConfig() Config {
+	db.RLock()
+	defer db.RUnlock()
+	return db.config
+}
+
+func (db *MemDB) CheckKey(key string) error {
+	return checkKey(db.GetConfig(), key)
+}
+
+func (db *MemDB) UpdateConfig(cf model.ConfigForm) (warning string, err error) {
+	db.Lock()
+	defer db.Unlock()
+	db.config, warning = applyConfigForm(db.config, cf)
+	return
+}
+
+func (db *MemDB) GenNewKey() error {
+	db.Lock()
+	defer db.Unlock()
+	db.config.Key = util.RandomString(secretKeySize)
+	db.config.KeyStarts = util.TimeNow()
+	return nil
+}
+
+func (db *MemDB) ChangePwd(oldPwd, newPwd string) error {
+	db.Lock()
+	defer db.Unlock()
+	if err := checkNewPwd(db.config, oldPwd, newPwd); err != nil {
+		return err
+	}
+	db.config.Password = newPwd
+	return nil
+}
+
+func (db *MemDB) InitPassword(pwd string) error {
+	db.Lock()
+	defer db.Unlock()
+	if err := checkInitPwd(db.config, pwd); err != nil {
+		return err
+	}
+	db.config.Password = pwd
+	db.config.Key = util.RandomString(secretKeySize)
+	db.config.KeyStarts = util.TimeNow()
+	return nil
+}
+
+func (db *MemDB) NewTxtMsg(msg string) (TxtMsg, error) {
+	return newTxtMsg(db.GetConfig(), msg)
+}
+
+// items 返回 bucket 对应的切片指针。
+func (db *MemDB) items(bucket string) (*[]TxtMsg, error) {
+	switch bucket {
+	case temp_bucket:
+		return &db.temp, nil
+	case perm_bucket:
+		return &db.perm, nil
+	}
+	return nil, fmt.Errorf("unknown bucket: %s", bucket)
+}
+
+// memUpdateIndex 与 bucketUpdateIndex 一样，最新的条目的流水号是 1.
+func memUpdateIndex(items []TxtMsg) {
+	max := len(items)
+	for i := range items {
+		items[i].Index = max - i
+	}
+}
+
+func (db *MemDB) InsertTxtMsg(tm TxtMsg) error {
+	db.Lock()
+	defer db.Unlock()
+	// 如果新消息的内容刚好与最新一条暂存消息相同，则不插入。
+	if n := len(db.temp); n > 0 && db.temp[n-1].Msg == tm.Msg {
+		return ErrSameAsLast
+	}
+	// 与 txLimitTemp 一样，插入后的条目数量小于等于 limit.
+	if limit := db.config.TempLimit; limit > 0 && len(db.temp) >= limit {
+		evicted := append([]TxtMsg(nil), db.temp[:len(db.temp)-limit+1]...)
+		for _, old := range evicted {
+			if err := db.deleteTxtMsg(old); err != nil {
+				return err
+			}
+		}
+	}
+	db.temp = append(db.temp, tm)
+	memUpdateIndex(db.temp)
+	return db.recordChange(Change{Op: model.OpInsert, ID: tm.ID, Msg: tm})
+}
+
+// findByID 返回 id 所在的切片及其位置，找不到时返回 ErrNoResult.
+func (db *MemDB) findByID(id string) (*[]TxtMsg, int, error) {
+	for _, items := range []*[]TxtMsg{&db.temp, &db.perm} {
+		for i, tm := range *items {
+			if tm.ID == id {
+				return items, i, nil
+			}
+		}
+	}
+	return nil, 0, ErrNoResult
+}
+
+func (db *MemDB) getByAliasIndex(a_or_i string) (tm TxtMsg, err error) {
+	if err = checkAlias(a_or_i); err == nil {
+		// 此时, a_or_i 是 alias
+		id, ok := db.alias[a_or_i]
+		if !ok {
+			return tm, ErrNoResult
+		}
+		return db.getByID(id)
+	}
+	// 此时, a_or_i 是 index
+	bucket, index := parseIndex(a_or_i)
+	items, _ := db.items(bucket)
+	for _, tm = range *items {
+		if tm.Index == index {
+			return tm, nil
+		}
+	}
+	return TxtMsg{}, ErrNoResult
+}
+
+func (db *MemDB) getByID(id string) (TxtMsg, error) {
+	items, i, err := db.findByID(id)
+	if err != nil {
+		return TxtMsg{}, err
+	}
+	return (*items)[i], nil
+}
+
+func (db *MemDB) deleteTxtMsg(tm TxtMsg) error {
+	items, i, err := db.findByID(tm.ID)
+	if err != nil {
+		return err
+	}
+	*items = append((*items)[:i], (*items)[i+1:]...)
+	if tm.Alias != "" {
+		delete(db.alias, tm.Alias)
+	}
+	memUpdateIndex(*items)
+	return db.recordChange(Change{Op: model.OpDelete, ID: tm.ID, Msg: tm})
+}
+
+func (db *MemDB) DeleteTxtMsg(id string) error {
+	db.Lock()
+	defer db.Unlock()
+	tm, err := db.getByID(id)
+	if err != nil {
+		return err
+	}
+	return db.deleteTxtMsg(tm)
+}
+
+func (db *MemDB) CliDeleteTxtMsg(a_or_i string) error {
+	db.Lock()
+	defer db.Unlock()
+	tm, err := db.getByAliasIndex(a_or_i)
+	if err != nil {
+		return err
+	}
+	return db.deleteTxtMsg(tm)
+}
+
+func (db *MemDB) GetByID(id string) (TxtMsg, error) {
+	db.RLock()
+	defer db.RUnlock()
+	return db.getByID(id)
+}
+
+func (db *MemDB) GetByAliasIndex(a_or_i string) (TxtMsg, error) {
+	db.RLock()
+	defer db.RUnlock()
+	return db.getByAliasIndex(a_or_i)
+}
+
+// ToggleCat 与 DB.ToggleCat 一样，转换时会改变 ID.
+func (db *MemDB) ToggleCat(tm TxtMsg) (after TxtMsg, err error) {
+	// DateID 会暂停一秒，因此必须在加锁之前生成新的 ID, 以免阻塞其他读写。
+	newID, err := model.DateID(db.GetConfig().TimeOffset)
+	if err != nil {
+		return
+	}
+	db.Lock()
+	defer db.Unlock()
+	src, i, err := db.findByID(tm.ID)
+	if err != nil {
+		return
+	}
+	after = (*src)[i]
+	target := &db.perm
+	after.Cat = CatPerm
+	if src == &db.perm {
+		target = &db.temp
+		after.Cat = CatTemp
+	}
+	after.ID = newID
+	*src = append((*src)[:i], (*src)[i+1:]...)
+	*target = append(*target, after)
+	if after.Alias != "" {
+		db.alias[after.Alias] = after.ID
+	}
+	memUpdateIndex(db.temp)
+	memUpdateIndex(db.perm)
+	if after, err = db.getByID(after.ID); err != nil {
+		return
+	}
+	err = db.recordChange(Change{
+		Op: model.OpToggle, ID: after.ID, OldID: tm.ID, Msg: after,
+	})
+	return
+}
+
+// memEditAlias 相当于 txEditAlias.
+func memEditAlias(aliases map[string]string, oldAlias, newAlias, id string) error {
+	if err := checkAlias(newAlias); err != nil {
+		return err
+	}
+	if oldAlias == newAlias {
+		return nil
+	}
+	if newAlias != "" {
+		if _, ok := aliases[newAlias]; ok {
+			return ErrKeyExists
+		}
+		aliases[newAlias] = id
+	}
+	if oldAlias != "" {
+		delete(aliases, oldAlias)
+	}
+	return nil
+}
+
+func (db *MemDB) Edit(form model.EditForm) error {
+	db.Lock()
+	defer db.Unlock()
+	items, i, err := db.findByID(form.ID)
+	if err != nil {
+		return err
+	}
+	tm := &(*items)[i]
+	if err := memEditAlias(db.alias, tm.Alias, form.Alias, tm.ID); err != nil {
+		return err
+	}
+	tm.Alias = form.Alias
+	tm.Msg = form.Msg
+	return db.recordChange(Change{Op: model.OpEdit, ID: tm.ID, Msg: *tm})
+}
+
+func (db *MemDB) UpdateAlias(a_or_i, newAlias string) error {
+	db.Lock()
+	defer db.Unlock()
+	found, err := db.getByAliasIndex(a_or_i)
+	if err != nil {
+		return err
+	}
+	items, i, err := db.findByID(found.ID)
+	if err != nil {
+		return err
+	}
+	tm := &(*items)[i]
+	if err := memEditAlias(db.alias, tm.Alias, newAlias, tm.ID); err != nil {
+		return err
+	}
+	tm.Alias = newAlias
+	return db.recordChange(Change{Op: model.OpAlias, ID: tm.ID, Msg: *tm})
+}
+
+// getTxtMsgLimit 从新到旧返回 ID 小于 start 的条目 (start 为空时从最新条目开始)。
+func (db *MemDB) getTxtMsgLimit(bucket, start string, limit int) (items []TxtMsg, err error) {
+	all, err := db.items(bucket)
+	if err != nil {
+		return nil, err
+	}
+	for i := len(*all) - 1; i >= 0 && len(items) < limit; i-- {
+		tm := (*all)[i]
+		if start != "" && tm.ID >= start {
+			continue
+		}
+		items = append(items, tm)
+	}
+	return
+}
+
+// sortedAliases 返回按字母顺序排列的全部别名。
+func (db *MemDB) sortedAliases() []string {
+	aliases := make([]string, 0, len(db.alias))
+	for alias := range db.alias {
+		aliases = append(aliases, alias)
+	}
+	sort.Strings(aliases)
+	return aliases
+}
+
+func (db *MemDB) getAliasLimit(start string, limit int) (items []TxtMsg, err error) {
+	for _, alias := range db.sortedAliases() {
+		if len(items) >= limit {
+			break
+		}
+		if start != "" && alias <= start {
+			continue
+		}
+		tm, err := db.getByID(db.alias[alias])
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, tm)
+	}
+	return
+}
+
+func (db *MemDB) GetRecentItems(limit int) ([]TxtMsg, error) {
+	db.RLock()
+	defer db.RUnlock()
+	tempItems, _ := db.getTxtMsgLimit(temp_bucket, "", limit)
+	permItems, _ := db.getTxtMsgLimit(perm_bucket, "", limit)
+	return append(tempItems, permItems...), nil
+}
+
+func (db *MemDB) GetMoreItems(bucket, start string, limit int) ([]TxtMsg, error) {
+	db.RLock()
+	defer db.RUnlock()
+	if limit <= 0 {
+		limit = db.config.EveryPageLimit
+	}
+	if bucket == alias_bucket {
+		return db.getAliasLimit(start, limit)
+	}
+	return db.getTxtMsgLimit(bucket, start, limit)
+}
+
+func (db *MemDB) CliGetTxtMsg(bucket string, index, limit int) (items []TxtMsg, err error) {
+	db.RLock()
+	defer db.RUnlock()
+	if index <= 1 {
+		index = 1
+	}
+	all, err := db.items(bucket)
+	if err != nil {
+		return nil, err
+	}
+	for i := len(*all) - 1; i >= 0; i-- {
+		tm := (*all)[i]
+		if tm.Index < index {
+			continue
+		}
+		if len(items) > 0 && len(items) >= limit {
+			break
+		}
+		items = append(items, tm)
+	}
+	return
+}
+
+func (db *MemDB) GetAllAliases() (aliases []model.Alias, err error) {
+	db.RLock()
+	defer db.RUnlock()
+	for _, alias := range db.sortedAliases() {
+		aliases = append(aliases, model.Alias{
+			ID:    alias,
+			MsgID: db.alias[alias],
+		})
+	}
+	return
+}
+
+func (db *MemDB) SearchTxtMsg(keyword string, buckets []string) (items []TxtMsg, err error) {
+	db.RLock()
+	defer db.RUnlock()
+	if len(buckets) == 0 {
+		buckets = []string{temp_bucket, perm_bucket}
+	}
+	for _, bucket := range buckets {
+		if bucket != temp_bucket && bucket != perm_bucket {
+			return nil, fmt.Errorf("unknown bucket: %s", bucket)
+		}
+		all, _ := db.items(bucket)
+		for _, tm := range *all {
+			if util.NoCaseContains(tm.Msg, keyword) {
+				items = append(items, tm)
+			}
+		}
+	}
+	return
+}
+
+// Reset 清空全部消息、别名与修改记录，然后载入 items (保留 config 与流水号不变)。
+// items 的 ID 不可重复，Cat 决定放入暂存消息还是永久消息。
+func (db *MemDB) Reset(items []TxtMsg) {
+	db.Lock()
+	defer db.Unlock()
+	db.temp = nil
+	db.perm = nil
+	db.alias = make(map[string]string)
+	db.changes = nil
+	db.versions = make(map[string]Version)
+	db.shares = make(map[string]Share)
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].ID < items[j].ID
+	})
+	for _, tm := range items {
+		if tm.Cat == CatPerm {
+			db.perm = append(db.perm, tm)
+		} else {
+			tm.Cat = CatTemp
+			db.temp = append(db.temp, tm)
+		}
+		if tm.Alias != "" {
+			db.alias[tm.Alias] = tm.ID
+		}
+	}
+	memUpdateIndex(db.temp)
+	memUpdateIndex(db.perm)
+}
+
+func (db *MemDB) ChangesSince(since uint64, limit int) (changes []Change, err error) {
+	db.RLock()
+	defer db.RUnlock()
+	if isTrimmed(since, db.trimmed) {
+		return nil, ErrChangesTrimmed
+	}
+	for _, ch := range db.changes {
+		if limit > 0 && len(changes) >= limit {
+			break
+		}
+		if ch.Seq > since {
+			changes = append(changes, ch)
+		}
+	}
+	return
+}
+
+func (db *MemDB) LastRecorded() (int64, error) {
+	db.RLock()
+	defer db.RUnlock()
+	if len(db.changes) == 0 {
+		return 0, nil
+	}
+	return lastRecorded(db.changes[len(db.changes)-1]), nil
+}
+
+func (db *MemDB) Snapshot() (changes []Change, last uint64, err error) {
+	db.RLock()
+	defer db.RUnlock()
+	r := memReplica{db}
+	for _, items := range [][]TxtMsg{db.temp, db.perm} {
+		for _, tm := range items {
+			ch, err := snapshotChange(r, tm)
+			if err != nil {
+				return nil, 0, err
+			}
+			changes = append(changes, ch)
+		}
+	}
+	return changes, db.seq, nil
+}
+
+func (db *MemDB) TrimChanges(keep int) (n int, err error) {
+	db.Lock()
+	defer db.Unlock()
+	if keep < 1 || len(db.changes) <= keep {
+		return 0, nil
+	}
+	n = len(db.changes) - keep
+	db.trimmed = db.changes[n-1].Seq
+	db.changes = append([]Change(nil), db.changes[n:]...)
+	return n, nil
+}
+
+func (db *MemDB) ApplyChanges(changes []Change) (n int, err error) {
+	db.Lock()
+	defer db.Unlock()
+	for _, ch := range changes {
+		ch, applied, err := applyChange(memReplica{db}, ch)
+		if err != nil {
+			return n, err
+		}
+		if !applied {
+			continue
+		}
+		if err := afterChange(db, ch); err != nil {
+			return n, err
+		}
+		n++
+	}
+	memUpdateIndex(db.temp)
+	memUpdateIndex(db.perm)
+	return
+}
+
+func (db *MemDB) GetPeerCursor(peer string) (uint64, error) {
+	db.RLock()
+	defer db.RUnlock()
+	return db.peers[peer], nil
+}
+
+func (db *MemDB) SetPeerCursor(peer string, seq uint64) error {
+	db.Lock()
+	defer db.Unlock()
+	db.peers[peer] = seq
+	return nil
+}
+
+// memReplica 是 replicaTx 的内存实现，调用前必须已取得写锁。
+type memReplica struct {
+	db *MemDB
+}
+
+func (r memReplica) nodeID() string {
+	return r.db.config.NodeID
+}
+
+func (r memReplica) getMsg(id string) (TxtMsg, bool, error) {
+	tm, err := r.db.getByID(id)
+	return tm, err == nil, nil
+}
+
+func (r memReplica) putMsg(tm TxtMsg) error {
+	if err := r.deleteMsg(tm.ID); err != nil {
+		return err
+	}
+	items, _ := r.db.items(getBucketName(tm))
+	// 来自其他服务器的消息不一定是最新的，因此要插入到正确的位置。
+	i := sort.Search(len(*items), func(i int) bool {
+		return (*items)[i].ID > tm.ID
+	})
+	*items = append(*items, TxtMsg{})
+	copy((*items)[i+1:], (*items)[i:])
+	(*items)[i] = tm
+	return nil
+}
+
+func (r memReplica) deleteMsg(id string) error {
+	if items, i, err := r.db.findByID(id); err == nil {
+		*items = append((*items)[:i], (*items)[i+1:]...)
+	}
+	return nil
+}
+
+func (r memReplica) getAlias(alias string) (string, bool) {
+	id, ok := r.db.alias[alias]
+	return id, ok
+}
+
+func (r memReplica) putAlias(alias, id string) error {
+	r.db.alias[alias] = id
+	return nil
+}
+
+func (r memReplica) deleteAlias(alias string) error {
+	delete(r.db.alias, alias)
+	return nil
+}
+
+func (r memReplica) getVersion(id string) (Version, bool, error) {
+	v, ok := r.db.versions[id]
+	return v, ok, nil
+}
+
+func (r memReplica) putVersion(id string, v Version) error {
+	r.db.versions[id] = v
+	return nil
+}
+
+func (r memReplica) nextSeq() (uint64, error) {
+	r.db.seq++
+	return r.db.seq, nil
+}
+
+func (r memReplica) putChange(ch Change) error {
+	r.db.changes = append(r.db.changes, ch)
+	r.db.hub.publish(ch)
+	return nil
+}
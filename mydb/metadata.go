@@ -22,6 +22,14 @@ const (
 	perm_bucket         = "permanent-bucket"
 	alias_bucket        = "alias-bucket"
 	config_bucket       = "config-bucket"
+	change_bucket       = "change-bucket"
+	version_bucket      = "version-bucket"
+	peer_bucket         = "peer-bucket"
+	device_bucket       = "device-bucket"
+	share_bucket        = "share-bucket"
+	webhook_bucket      = "webhook-bucket"
+	delivery_bucket     = "delivery-bucket"
+	sshkey_bucket       = "sshkey-bucket"
 	hour                = 60 * 60
 	day                 = 24 * hour
 	defaultKeyMaxAge    = 30 * day
@@ -30,22 +38,44 @@ const (
 	defaultPageLimit    = 30
 	BeijingTime         = "+8" // 北京时间
 	secretKeySize       = 12   // 不需要太高的安全性
+	nodeIDSize          = 6
 )
 
-var defaultConfig = Config{
-	Password:       "abc",
-	Key:            util.RandomString(secretKeySize),
-	KeyStarts:      util.TimeNow(),
-	KeyMaxAge:      defaultKeyMaxAge,
-	MsgSizeLimit:   defaultMsgSizeLimit,
-	TempLimit:      defaultTempLimit,
-	EveryPageLimit: defaultPageLimit,
-	TimeOffset:     BeijingTime,
+// DefaultPassword 是旧版本的默认主密码，现在只用于演示版。
+// 新的数据库没有主密码，需要先执行 txt init (见 InitPassword)。
+const DefaultPassword = "abc"
+
+// 供其他 package 使用的 bucket 名称
+const (
+	TempBucket  = temp_bucket
+	PermBucket  = perm_bucket
+	AliasBucket = alias_bucket
+)
+
+// newConfig 返回新数据库的默认设置，每次调用都生成新的密钥与 NodeID.
+func newConfig() Config {
+	return Config{
+		Password:       "",
+		Key:            util.RandomString(secretKeySize),
+		KeyStarts:      util.TimeNow(),
+		KeyMaxAge:      defaultKeyMaxAge,
+		MsgSizeLimit:   defaultMsgSizeLimit,
+		TempLimit:      defaultTempLimit,
+		EveryPageLimit: defaultPageLimit,
+		TimeOffset:     BeijingTime,
+		NodeID:         util.RandomString(nodeIDSize),
+	}
 }
 
 var ErrNoResult = errors.New("error-database-no-result")
 var ErrKeyExists = errors.New("error-database-key-exists")
 var ErrMsgTooLong = errors.New("error-message-too-long")
+var ErrWrongKey = errors.New("wrong key")
+var ErrKeyExpired = errors.New("the key is expired")
+var ErrPasswordSet = errors.New("the password has already been set")
+var ErrOldDatabase = errors.New("the database was created by an older version, open it once without -readonly to upgrade it")
+var ErrChangesTrimmed = errors.New("the change log has been trimmed, some changes are no longer available")
+var ErrBadAlias = errors.New("别名不可采用“以 T 或 P 开头紧跟数字”的形式")
 
 type (
 	Config = model.Config
@@ -56,20 +86,40 @@ func txCreateBucket(tx *bolt.Tx, name string) error {
 	return err
 }
 
+// allBuckets 是数据库需要的全部 bucket.
+var allBuckets = []string{
+	config_bucket, temp_bucket, perm_bucket, alias_bucket,
+	change_bucket, version_bucket, peer_bucket, device_bucket,
+	share_bucket, webhook_bucket, delivery_bucket, sshkey_bucket,
+}
+
 func (db *DB) createBuckets() error {
-	tx := db.BeginWrite()
+	tx := db.beginWrite()
 	defer tx.Rollback()
 
-	e1 := txCreateBucket(tx, config_bucket)
-	e2 := txCreateBucket(tx, temp_bucket)
-	e3 := txCreateBucket(tx, perm_bucket)
-	e4 := txCreateBucket(tx, alias_bucket)
-	if err := util.WrapErrors(e1, e2, e3, e4); err != nil {
+	var errs []error
+	for _, name := range allBuckets {
+		errs = append(errs, txCreateBucket(tx, name))
+	}
+	if err := util.WrapErrors(errs...); err != nil {
 		return err
 	}
 	return tx.Commit()
 }
 
+// checkBuckets 检查全部 bucket 是否存在。用于只读方式，
+// 因为只读方式无法创建旧版本的数据库缺少的 bucket.
+func (db *DB) checkBuckets() error {
+	return db.DB.View(func(tx *bolt.Tx) error {
+		for _, name := range allBuckets {
+			if tx.Bucket([]byte(name)) == nil {
+				return fmt.Errorf("%w: missing %s", ErrOldDatabase, name)
+			}
+		}
+		return nil
+	})
+}
+
 func getBucketName(tm TxtMsg) string {
 	if tm.Cat == CatTemp {
 		return temp_bucket
@@ -90,41 +140,43 @@ func txPutObject(tx *bolt.Tx, bucket, key string, v interface{}) error {
 	return bucketPutObject(b, key, v)
 }
 
-// txLimitTemp 限制 temp_bucket 中的数量，如果达到 limit 就删除旧条目。
+// txLimitTemp 限制 temp_bucket 中的数量，如果达到 limit 就删除旧条目 (包括其别名)。
 // 即, txLimitTemp 执行后，temp_bucket 中的条目数量应小于 limit (而不是小于等于 limit)。
 // 通常在 bucket.Put 之前执行本函数，即, bucket.Put 之后的条目数量小于等于 limit。
-func txLimitTemp(tx *bolt.Tx, limit int) error {
+// 返回被删除的条目，以便记录到 change log.
+func txLimitTemp(tx *bolt.Tx, limit int) (evicted []TxtMsg, err error) {
 	if limit < 1 {
-		return nil
+		return nil, nil
 	}
 	bucket := tx.Bucket([]byte(temp_bucket))
 	c := bucket.Cursor()
 
-	// 特殊情况优化 1. 如果 temp_bucket 中的条目数量小于 limit，则不需要删除任何条目。
+	// 特殊情况优化. 如果 temp_bucket 中的条目数量小于 limit，则不需要删除任何条目。
 	n := bucket.Stats().KeyN
 	if n < limit {
-		return nil
+		return nil, nil
 	}
 
-	// 特殊情况优化 2. 如果 temp_bucket 中的条目数量刚好等于 limit，
-	// 则只要删除最早的 1 个条目。
-	if n == limit {
-		k, _ := c.First()
-		return bucket.Delete(k)
+	// 从最早的条目开始，删除 n-limit+1 个条目。
+	// 先收集再删除，避免一边遍历一边删除。
+	for k, v := c.First(); k != nil && len(evicted) < n-limit+1; k, v = c.Next() {
+		tm, err := model.UnmarshalTxtMsg(v)
+		if err != nil {
+			return nil, err
+		}
+		evicted = append(evicted, tm)
 	}
-
-	// 普通情况（无法优化的情况）
-	i := 1
-	for k, _ := c.Last(); k != nil; k, _ = c.Prev() {
-		if i < limit {
-			i++
-			continue
+	for _, tm := range evicted {
+		if err := bucket.Delete([]byte(tm.ID)); err != nil {
+			return nil, err
 		}
-		if err := bucket.Delete(k); err != nil {
-			return err
+		if tm.Alias != "" {
+			if err := txDeleteAlias(tx, tm.Alias); err != nil {
+				return nil, err
+			}
 		}
 	}
-	return nil
+	return evicted, nil
 }
 
 func txGetBytes(tx *bolt.Tx, bucket, key string) ([]byte, error) {
@@ -183,7 +235,7 @@ func txGetByIndex(tx *bolt.Tx, bucket string, index int) (tm TxtMsg, err error)
 	return tm, ErrNoResult
 }
 
-func (db *DB) getConfig() (config Config, err error) {
+func (db *DB) loadConfig() (config Config, err error) {
 	data, err := db.getBytes(config_bucket, config_key)
 	if err != nil {
 		return
@@ -193,7 +245,12 @@ func (db *DB) getConfig() (config Config, err error) {
 }
 
 func (db *DB) initConfig() error {
-	config, err := db.getConfig()
+	config, err := db.loadConfig()
+	if err == nil && config.NodeID == "" {
+		// 旧版本的数据库没有 NodeID
+		config.NodeID = util.RandomString(nodeIDSize)
+		return db.updateConfig(config)
+	}
 	if err == nil {
 		db.Config = config
 		return nil
@@ -202,7 +259,7 @@ func (db *DB) initConfig() error {
 		return err
 	}
 	// 剩下的唯一可能性就是 err == ErrNoResult
-	return db.updateConfig(defaultConfig)
+	return db.updateConfig(newConfig())
 }
 
 func (db *DB) updateConfig(config Config) error {
@@ -219,53 +276,13 @@ func (db *DB) updateConfig(config Config) error {
 
 // UpdateConfig updates the config from a ConfigForm.
 func (db *DB) UpdateConfig(cf model.ConfigForm) (warning string, err error) {
-	var ignore []string
-	config := db.Config
-
-	maxAge := cf.KeyMaxAge * day
-	if maxAge < 1 {
-		ignore = append(ignore, "key_max_age")
-	} else {
-		config.KeyMaxAge = cf.KeyMaxAge * day
-	}
-
-	if cf.MsgSizeLimit < 256 {
-		ignore = append(ignore, "msg_size_limit")
-	} else {
-		config.MsgSizeLimit = cf.MsgSizeLimit
-	}
-
-	if cf.TempLimit < 1 {
-		ignore = append(ignore, "temp_msg_limit")
-	} else {
-		config.TempLimit = cf.TempLimit
-	}
-
-	if cf.EveryPageLimit < 1 {
-		ignore = append(ignore, "page_limit")
-	} else {
-		config.EveryPageLimit = cf.EveryPageLimit
-	}
-
-	if _, err = model.DateID(cf.TimeOffset); err != nil {
-		ignore = append(ignore, "timeone_offset")
-	} else {
-		config.TimeOffset = cf.TimeOffset
-	}
-
-	if err = db.updateConfig(config); err != nil {
-		return
-	}
-	if len(ignore) > 0 {
-		warning = "ignore: " + strings.Join(ignore, ", ")
-	}
-	// 要记得更新 db.Config
-	db.Config = config
+	config, warning := applyConfigForm(db.Config, cf)
+	err = db.updateConfig(config)
 	return
 }
 
 func (db *DB) GenNewKey() error {
-	config, err := db.getConfig()
+	config, err := db.loadConfig()
 	if err != nil {
 		return err
 	}
@@ -277,24 +294,30 @@ func (db *DB) GenNewKey() error {
 // ChangePassword 修改密码，其中 oldPwd 由于涉及 ip 尝试次数，因此应在
 // 使用本函数前使用 db.CheckPassword 验证 oldPwd.
 func (db *DB) ChangePwd(oldPwd, newPwd string) error {
-	if oldPwd == "" {
-		return fmt.Errorf("the current password is empty")
-	}
-	if newPwd == "" {
-		return fmt.Errorf("the new password is empty")
+	config, err := db.loadConfig()
+	if err != nil {
+		return err
 	}
-	if newPwd == oldPwd {
-		return fmt.Errorf("the two passwords are the same")
+	if err := checkNewPwd(config, oldPwd, newPwd); err != nil {
+		return err
 	}
+	config.Password = newPwd
+	return db.updateConfig(config)
+}
 
-	config, err := db.getConfig()
+// InitPassword 设置初始主密码并生成新的密钥，
+// 只能用于尚未设置主密码 (或仍在使用旧版本默认密码) 的数据库。
+func (db *DB) InitPassword(pwd string) error {
+	config, err := db.loadConfig()
 	if err != nil {
 		return err
 	}
-	if config.Password != oldPwd {
-		return fmt.Errorf("the current password is wrong")
+	if err := checkInitPwd(config, pwd); err != nil {
+		return err
 	}
-	config.Password = newPwd
+	config.Password = pwd
+	config.Key = util.RandomString(secretKeySize)
+	config.KeyStarts = util.TimeNow()
 	return db.updateConfig(config)
 }
 
@@ -312,11 +335,7 @@ func (db *DB) newDateID() (string, error) {
 }
 
 func (db *DB) NewTxtMsg(msg string) (TxtMsg, error) {
-	if len(msg) > db.Config.MsgSizeLimit {
-		err := fmt.Errorf("size: %d, limit: %d", len(msg), db.Config.MsgSizeLimit)
-		return TxtMsg{}, util.WrapErrors(ErrMsgTooLong, err)
-	}
-	return model.NewTxtMsg(msg, db.Config.TimeOffset)
+	return newTxtMsg(db.Config, msg)
 }
 
 func bucketUpdateIndex(bucket *bolt.Bucket) error {
@@ -422,7 +441,7 @@ func checkAlias(alias string) error {
 	}
 	alias = alias[1:]
 	if _, err := strconv.Atoi(alias); err == nil {
-		return fmt.Errorf("别名不可采用“以 T 或 P 开头紧跟数字”的形式")
+		return ErrBadAlias
 	}
 	return nil
 }
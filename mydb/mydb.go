@@ -2,8 +2,6 @@ package mydb
 
 import (
 	"fmt"
-	"strconv"
-	"strings"
 	"time"
 
 	"github.com/ahui2016/txt/model"
@@ -29,6 +27,8 @@ type DB struct {
 	Path   string
 	DB     *bolt.DB
 	Config Config
+
+	hub *changeHub
 }
 
 func (db *DB) Open(dbPath string) (err error) {
@@ -37,38 +37,54 @@ func (db *DB) Open(dbPath string) (err error) {
 		return err
 	}
 	db.Path = dbPath
+	db.hub = newChangeHub()
 	e1 := db.createBuckets()
 	e2 := db.initConfig()
 	return util.WrapErrors(e1, e2)
 }
 
-func (db *DB) BeginWrite() *bolt.Tx {
+// OpenReadOnly 以只读方式打开数据库，此时其他进程 (比如备份程序) 也可同时读取。
+// 注意：只读方式不会创建 bucket 与默认 config, 因此数据库文件必须已存在，
+// 并且旧版本的数据库必须先以普通方式打开一次 (补上新增的 bucket), 否则返回 ErrOldDatabase.
+func (db *DB) OpenReadOnly(dbPath string) (err error) {
+	db.DB, err = bolt.Open(dbPath, 0600, &bolt.Options{
+		Timeout:  1 * time.Second,
+		ReadOnly: true,
+	})
+	if err != nil {
+		return err
+	}
+	db.Path = dbPath
+	db.hub = newChangeHub()
+	if err = db.checkBuckets(); err != nil {
+		db.DB.Close()
+		return err
+	}
+	db.Config, err = db.loadConfig()
+	return err
+}
+
+func (db *DB) Close() error {
+	return db.DB.Close()
+}
+
+func (db *DB) beginWrite() *bolt.Tx {
 	tx, err := db.DB.Begin(true)
 	util.Panic(err)
 	return tx
 }
 
-func (db *DB) BeginWriteBucket(name string) (*bolt.Tx, *bolt.Bucket) {
-	tx, err := db.DB.Begin(true)
-	util.Panic(err)
-	b := tx.Bucket([]byte(name))
-	return tx, b
+// Subscribe 订阅修改记录，每当修改 commit 之后就会收到通知。
+func (db *DB) Subscribe() (<-chan Change, func()) {
+	return db.hub.subscribe()
 }
 
-func (db *DB) BeginRead() *bolt.Tx {
-	tx, err := db.DB.Begin(false)
-	util.Panic(err)
-	return tx
+func (db *DB) GetConfig() Config {
+	return db.Config
 }
 
 func (db *DB) CheckKey(key string) error {
-	if key != db.Config.Key {
-		return fmt.Errorf("wrong key")
-	}
-	if util.TimeNow() > db.Config.KeyStarts+db.Config.KeyMaxAge {
-		return fmt.Errorf("the key is expired")
-	}
-	return nil
+	return checkKey(db.Config, key)
 }
 
 // InsertTxtMsg 注意此时必然插入到 temp_bucket, 并且 Alias 必然为空。
@@ -84,10 +100,21 @@ func (db *DB) InsertTxtMsg(tm TxtMsg) error {
 		if last.Msg == tm.Msg {
 			return ErrSameAsLast
 		}
-		if err := txLimitTemp(tx, db.Config.TempLimit); err != nil {
+		evicted, err := txLimitTemp(tx, db.Config.TempLimit)
+		if err != nil {
 			return err
 		}
-		return txPutObject(tx, temp_bucket, tm.ID, tm)
+		for _, old := range evicted {
+			if err := db.txRecordChange(tx, Change{
+				Op: model.OpDelete, ID: old.ID, Msg: old,
+			}); err != nil {
+				return err
+			}
+		}
+		if err := txPutObject(tx, temp_bucket, tm.ID, tm); err != nil {
+			return err
+		}
+		return db.txRecordChange(tx, Change{Op: model.OpInsert, ID: tm.ID, Msg: tm})
 	}); err != nil {
 		return err
 	}
@@ -106,7 +133,7 @@ func (db *DB) deleteTxtMsg(tm TxtMsg) error {
 				return err
 			}
 		}
-		return nil
+		return db.txRecordChange(tx, Change{Op: model.OpDelete, ID: tm.ID, Msg: tm})
 	})
 	if err != nil {
 		return err
@@ -180,9 +207,13 @@ func (db *DB) ToggleCat(tm TxtMsg) (after TxtMsg, err error) {
 			return err
 		}
 		if after.Alias != "" {
-			err = txPutAlias(tx, after.Alias, after.ID, true)
+			if err := txPutAlias(tx, after.Alias, after.ID, true); err != nil {
+				return err
+			}
 		}
-		return err
+		return db.txRecordChange(tx, Change{
+			Op: model.OpToggle, ID: after.ID, OldID: tm.ID, Msg: after,
+		})
 	})
 	if err != nil {
 		return
@@ -202,14 +233,7 @@ func (db *DB) GetByAliasIndex(a_or_i string) (tm TxtMsg, err error) {
 		}
 
 		// 此时, a_or_i 是 index
-		index := strings.ToUpper(a_or_i)
-		bucket := temp_bucket
-		// index 的头部要么是 T, 要么是 P
-		if index[0] == 'P' {
-			bucket = perm_bucket
-		}
-		// index 的尾部是数字
-		i, _ := strconv.Atoi(index[1:])
+		bucket, i := parseIndex(a_or_i)
 		tm, err = txGetByIndex(tx, bucket, i)
 		return err
 	})
@@ -332,7 +356,10 @@ func (db *DB) Edit(form model.EditForm) error {
 		}
 		tm.Alias = form.Alias
 		tm.Msg = form.Msg
-		return txPutObject(tx, getBucketName(tm), tm.ID, tm)
+		if err := txPutObject(tx, getBucketName(tm), tm.ID, tm); err != nil {
+			return err
+		}
+		return db.txRecordChange(tx, Change{Op: model.OpEdit, ID: tm.ID, Msg: tm})
 	})
 	return err
 }
@@ -347,7 +374,10 @@ func (db *DB) UpdateAlias(a_or_i, newAlias string) error {
 			return err
 		}
 		tm.Alias = newAlias
-		return txPutObject(tx, getBucketName(tm), tm.ID, tm)
+		if err := txPutObject(tx, getBucketName(tm), tm.ID, tm); err != nil {
+			return err
+		}
+		return db.txRecordChange(tx, Change{Op: model.OpAlias, ID: tm.ID, Msg: tm})
 	})
 	return err
 }
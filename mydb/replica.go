@@ -0,0 +1,469 @@
+package mydb
+
+import (
+	"encoding/binary"
+
+	"github.com/ahui2016/txt/model"
+	"github.com/ahui2016/txt/util"
+	"github.com/vmihailenco/msgpack/v5"
+	bolt "go.etcd.io/bbolt"
+)
+
+type (
+	Change  = model.Change
+	Version = model.Version
+)
+
+// replicaTx 是记录与应用修改记录 (change log) 所需的底层操作，
+// DB 与 MemDB 各自实现，而冲突处理等逻辑则由两者共用。
+type replicaTx interface {
+	nodeID() string
+	getMsg(id string) (tm TxtMsg, ok bool, err error)
+	putMsg(tm TxtMsg) error // 根据 Cat 放入对应的 bucket, 并从另一个 bucket 删除
+	deleteMsg(id string) error
+	getAlias(alias string) (id string, ok bool)
+	putAlias(alias, id string) error
+	deleteAlias(alias string) error
+	getVersion(id string) (v Version, ok bool, err error)
+	putVersion(id string, v Version) error
+	nextSeq() (uint64, error)
+	putChange(ch Change) error
+}
+
+// isTrimmed 判断流水号大于 since 的修改记录是否有一部分已被删除 (trimmed 是已删除的最大流水号)。
+// 包括 since 为零的情况：新的 peer 无法再从修改记录获得全部消息，应改用 Snapshot.
+func isTrimmed(since, trimmed uint64) bool {
+	return since < trimmed
+}
+
+// updateVersion 只有当 v 比已有的 Version 更新时才更新。
+func updateVersion(r replicaTx, id string, v Version) error {
+	if id == "" {
+		return nil
+	}
+	cur, ok, err := r.getVersion(id)
+	if err != nil {
+		return err
+	}
+	if ok && !v.After(cur) {
+		return nil
+	}
+	return r.putVersion(id, v)
+}
+
+// isNewer 判断 v 是否比本地 id 的 Version 更新，本地没有记录时也算更新。
+func isNewer(r replicaTx, id string, v Version) (bool, error) {
+	cur, ok, err := r.getVersion(id)
+	if err != nil {
+		return false, err
+	}
+	return !ok || v.After(cur), nil
+}
+
+// recordChange 为 ch 分配本地流水号并写入 change log, 同时更新相关消息的 Version.
+// 本地产生的修改 (ch.Origin 为空) 总是比本地已有的 Version 更新。
+func recordChange(r replicaTx, ch Change) (Change, error) {
+	seq, err := r.nextSeq()
+	if err != nil {
+		return ch, err
+	}
+	ch.Seq = seq
+	ch.Recorded = util.TimeNow()
+	if ch.Origin == "" {
+		ch.Time = util.TimeNow()
+		for _, id := range []string{ch.ID, ch.OldID} {
+			cur, ok, err := r.getVersion(id)
+			if err != nil {
+				return ch, err
+			}
+			if ok && cur.Time >= ch.Time {
+				ch.Time = cur.Time + 1
+			}
+		}
+		ch.Origin = r.nodeID()
+		ch.OriginSeq = seq
+	}
+	if err := updateVersion(r, ch.ID, ch.Version); err != nil {
+		return ch, err
+	}
+	if err := updateVersion(r, ch.OldID, ch.Version); err != nil {
+		return ch, err
+	}
+	return ch, r.putChange(ch)
+}
+
+// lastRecorded 返回 ch 写入本地 change log 的时间。
+// 旧版本的修改记录没有 Recorded, 此时使用 Version.Time.
+func lastRecorded(ch Change) int64 {
+	if ch.Recorded == 0 {
+		return ch.Time
+	}
+	return ch.Recorded
+}
+
+// snapshotChange 把一条现有的消息表示为 OpInsert 修改记录，供新的 peer 首次同步。
+// 启用 change log 之前就已存在的消息没有 Version, 此时使用最旧的 Version (Time 为零)，
+// 因此该消息此后的任何修改都比它新。
+func snapshotChange(r replicaTx, tm TxtMsg) (Change, error) {
+	v, ok, err := r.getVersion(tm.ID)
+	if err != nil {
+		return Change{}, err
+	}
+	if !ok {
+		v = Version{Origin: r.nodeID()}
+	}
+	return Change{Op: model.OpInsert, ID: tm.ID, Msg: tm, Version: v}, nil
+}
+
+// removeMsg 删除消息及其别名 (如果存在)。
+func removeMsg(r replicaTx, id string) error {
+	tm, ok, err := r.getMsg(id)
+	if err != nil || !ok {
+		return err
+	}
+	if err := r.deleteMsg(id); err != nil {
+		return err
+	}
+	if tm.Alias != "" {
+		if aliasID, ok := r.getAlias(tm.Alias); ok && aliasID == id {
+			return r.deleteAlias(tm.Alias)
+		}
+	}
+	return nil
+}
+
+// putRemoteMsg 写入来自其他服务器的消息，并处理别名冲突：
+// 如果别名已被本地另一条消息占用，则 Version 较新的一方保留别名，另一方的别名被清空。
+func putRemoteMsg(r replicaTx, tm TxtMsg, v Version) error {
+	old, ok, err := r.getMsg(tm.ID)
+	if err != nil {
+		return err
+	}
+	if ok && old.Alias != "" && old.Alias != tm.Alias {
+		if aliasID, ok := r.getAlias(old.Alias); ok && aliasID == tm.ID {
+			if err := r.deleteAlias(old.Alias); err != nil {
+				return err
+			}
+		}
+	}
+	if tm.Alias != "" {
+		if otherID, ok := r.getAlias(tm.Alias); ok && otherID != tm.ID {
+			other, found, err := r.getMsg(otherID)
+			if err != nil {
+				return err
+			}
+			otherVer, _, err := r.getVersion(otherID)
+			if err != nil {
+				return err
+			}
+			if found && !v.After(otherVer) {
+				tm.Alias = "" // 本地的别名较新
+			} else if found {
+				other.Alias = ""
+				if err := r.putMsg(other); err != nil {
+					return err
+				}
+			}
+		}
+		if tm.Alias != "" {
+			if err := r.putAlias(tm.Alias, tm.ID); err != nil {
+				return err
+			}
+		}
+	}
+	return r.putMsg(tm)
+}
+
+// applyChange 应用一条来自其他服务器的修改记录，返回写入本地 change log 的修改记录，
+// 以及是否实际产生了修改。
+// 同一条消息的修改以 Version 较新者为准，因此各服务器最终会得到相同的结果。
+// 注意：应用后需要更新流水号 (Index)。
+func applyChange(r replicaTx, ch Change) (Change, bool, error) {
+	if ch.Origin == "" || ch.Origin == r.nodeID() {
+		return ch, false, nil
+	}
+	newer, err := isNewer(r, ch.ID, ch.Version)
+	if err != nil {
+		return ch, false, err
+	}
+	switch ch.Op {
+	case model.OpDelete:
+		if !newer {
+			return ch, false, nil
+		}
+		if err := removeMsg(r, ch.ID); err != nil {
+			return ch, false, err
+		}
+	case model.OpToggle:
+		oldNewer, err := isNewer(r, ch.OldID, ch.Version)
+		if err != nil {
+			return ch, false, err
+		}
+		if !newer && !oldNewer {
+			return ch, false, nil
+		}
+		if oldNewer {
+			if err := removeMsg(r, ch.OldID); err != nil {
+				return ch, false, err
+			}
+		}
+		if newer {
+			if err := putRemoteMsg(r, ch.Msg, ch.Version); err != nil {
+				return ch, false, err
+			}
+		}
+	default:
+		if !newer {
+			return ch, false, nil
+		}
+		if err := putRemoteMsg(r, ch.Msg, ch.Version); err != nil {
+			return ch, false, err
+		}
+	}
+	ch, err = recordChange(r, ch)
+	return ch, true, err
+}
+
+func itob(v uint64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, v)
+	return b
+}
+
+func btoi(b []byte) uint64 {
+	return binary.BigEndian.Uint64(b)
+}
+
+// boltReplica 是 replicaTx 的 bolt 实现。
+type boltReplica struct {
+	tx   *bolt.Tx
+	node string
+	hub  *changeHub
+}
+
+func (db *DB) replica(tx *bolt.Tx) boltReplica {
+	return boltReplica{tx: tx, node: db.Config.NodeID, hub: db.hub}
+}
+
+func (r boltReplica) nodeID() string {
+	return r.node
+}
+
+func (r boltReplica) getMsg(id string) (tm TxtMsg, ok bool, err error) {
+	tm, err = txGetByID(r.tx, id)
+	if err == ErrNoResult {
+		return tm, false, nil
+	}
+	return tm, err == nil, err
+}
+
+func (r boltReplica) putMsg(tm TxtMsg) error {
+	other := temp_bucket
+	if tm.Cat == CatTemp {
+		other = perm_bucket
+	}
+	if err := r.tx.Bucket([]byte(other)).Delete([]byte(tm.ID)); err != nil {
+		return err
+	}
+	return txPutObject(r.tx, getBucketName(tm), tm.ID, tm)
+}
+
+func (r boltReplica) deleteMsg(id string) error {
+	for _, bucket := range []string{temp_bucket, perm_bucket} {
+		if err := r.tx.Bucket([]byte(bucket)).Delete([]byte(id)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r boltReplica) getAlias(alias string) (string, bool) {
+	id := r.tx.Bucket([]byte(alias_bucket)).Get([]byte(alias))
+	return string(id), id != nil
+}
+
+func (r boltReplica) putAlias(alias, id string) error {
+	return txPutAlias(r.tx, alias, id, true)
+}
+
+func (r boltReplica) deleteAlias(alias string) error {
+	return txDeleteAlias(r.tx, alias)
+}
+
+func (r boltReplica) getVersion(id string) (v Version, ok bool, err error) {
+	data := r.tx.Bucket([]byte(version_bucket)).Get([]byte(id))
+	if data == nil {
+		return v, false, nil
+	}
+	err = msgpack.Unmarshal(data, &v)
+	return v, err == nil, err
+}
+
+func (r boltReplica) putVersion(id string, v Version) error {
+	return txPutObject(r.tx, version_bucket, id, v)
+}
+
+func (r boltReplica) nextSeq() (uint64, error) {
+	return r.tx.Bucket([]byte(change_bucket)).NextSequence()
+}
+
+func (r boltReplica) putChange(ch Change) error {
+	data, err := msgpack.Marshal(ch)
+	if err != nil {
+		return err
+	}
+	// 只有 commit 成功后才通知订阅者
+	r.tx.OnCommit(func() { r.hub.publish(ch) })
+	return r.tx.Bucket([]byte(change_bucket)).Put(itob(ch.Seq), data)
+}
+
+// txRecordChange 在同一个事务中记录一条本地产生的修改。
+func (db *DB) txRecordChange(tx *bolt.Tx, ch Change) error {
+	ch, err := recordChange(db.replica(tx), ch)
+	if err != nil {
+		return err
+	}
+	return afterChange(boltEffects{tx}, ch)
+}
+
+// ChangesSince 返回流水号大于 since 的修改记录 (从旧到新)，最多 limit 条。
+// 如果其中一部分已被 TrimChanges 删除，则返回 ErrChangesTrimmed.
+func (db *DB) ChangesSince(since uint64, limit int) (changes []Change, err error) {
+	err = db.DB.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket([]byte(change_bucket)).Cursor()
+		if first, _ := c.First(); first != nil && isTrimmed(since, btoi(first)-1) {
+			return ErrChangesTrimmed
+		}
+		for k, v := c.Seek(itob(since + 1)); k != nil; k, v = c.Next() {
+			if limit > 0 && len(changes) >= limit {
+				break
+			}
+			var ch Change
+			if err := msgpack.Unmarshal(v, &ch); err != nil {
+				return err
+			}
+			changes = append(changes, ch)
+		}
+		return nil
+	})
+	return
+}
+
+// LastRecorded 返回最后一条修改记录写入本地 change log 的时间，没有任何修改记录时返回零。
+// 来自其他服务器的修改保留原来的 Version.Time (可能早于之前的修改), 因此使用 Recorded.
+func (db *DB) LastRecorded() (last int64, err error) {
+	err = db.DB.View(func(tx *bolt.Tx) error {
+		_, v := tx.Bucket([]byte(change_bucket)).Cursor().Last()
+		if v == nil {
+			return nil
+		}
+		var ch Change
+		if err := msgpack.Unmarshal(v, &ch); err != nil {
+			return err
+		}
+		last = lastRecorded(ch)
+		return nil
+	})
+	return
+}
+
+// Snapshot 把全部消息表示为修改记录，并返回当前最后一条修改记录的流水号。
+// 新的 peer 应用这些记录后，再从 last 开始获取之后的修改记录即可，
+// 因此即使旧的修改记录已被删除 (或消息早于 change log), 新的 peer 也能获得全部消息。
+func (db *DB) Snapshot() (changes []Change, last uint64, err error) {
+	err = db.DB.View(func(tx *bolt.Tx) error {
+		r := db.replica(tx)
+		for _, bucket := range []string{temp_bucket, perm_bucket} {
+			err := tx.Bucket([]byte(bucket)).ForEach(func(_, v []byte) error {
+				var tm TxtMsg
+				if err := msgpack.Unmarshal(v, &tm); err != nil {
+					return err
+				}
+				ch, err := snapshotChange(r, tm)
+				if err != nil {
+					return err
+				}
+				changes = append(changes, ch)
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+		last = tx.Bucket([]byte(change_bucket)).Sequence()
+		return nil
+	})
+	return
+}
+
+// TrimChanges 只保留最新的 keep 条修改记录，返回删除的条数。
+func (db *DB) TrimChanges(keep int) (n int, err error) {
+	if keep < 1 {
+		return 0, nil
+	}
+	err = db.DB.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(change_bucket))
+		last, _ := b.Cursor().Last()
+		if last == nil || btoi(last) <= uint64(keep) {
+			return nil
+		}
+		cutoff := btoi(last) - uint64(keep)
+		// 先收集再删除，避免一边遍历一边删除。
+		var keys [][]byte
+		c := b.Cursor()
+		for k, _ := c.First(); k != nil && btoi(k) <= cutoff; k, _ = c.Next() {
+			keys = append(keys, k)
+		}
+		for _, k := range keys {
+			if err := b.Delete(k); err != nil {
+				return err
+			}
+		}
+		n = len(keys)
+		return nil
+	})
+	return
+}
+
+// ApplyChanges 应用来自其他服务器的修改记录，返回实际产生修改的条数。
+func (db *DB) ApplyChanges(changes []Change) (n int, err error) {
+	err = db.DB.Update(func(tx *bolt.Tx) error {
+		r := db.replica(tx)
+		for _, ch := range changes {
+			ch, applied, err := applyChange(r, ch)
+			if err != nil {
+				return err
+			}
+			if !applied {
+				continue
+			}
+			if err := afterChange(boltEffects{tx}, ch); err != nil {
+				return err
+			}
+			n++
+		}
+		return nil
+	})
+	if err != nil || n == 0 {
+		return
+	}
+	err = db.updateAllIndex()
+	return
+}
+
+// GetPeerCursor 返回上次从 peer 获取到的最后一条修改记录的流水号。
+func (db *DB) GetPeerCursor(peer string) (seq uint64, err error) {
+	err = db.DB.View(func(tx *bolt.Tx) error {
+		if v := tx.Bucket([]byte(peer_bucket)).Get([]byte(peer)); v != nil {
+			seq = btoi(v)
+		}
+		return nil
+	})
+	return
+}
+
+func (db *DB) SetPeerCursor(peer string, seq uint64) error {
+	return db.DB.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(peer_bucket)).Put([]byte(peer), itob(seq))
+	})
+}
@@ -0,0 +1,259 @@
+package mydb
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/ahui2016/txt/model"
+)
+
+// testStores 返回两种实现的新数据库。
+func testStores(t *testing.T) map[string]Store {
+	return map[string]Store{"DB": openTestDB(t), "MemDB": NewMemDB()}
+}
+
+func allChanges(t *testing.T, s Store) []Change {
+	t.Helper()
+	changes, err := s.ChangesSince(0, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return changes
+}
+
+// remote 返回一条来自 peer 的修改记录。
+func remote(op model.ChangeOp, tm TxtMsg, time int64, seq uint64) Change {
+	return Change{Op: op, ID: tm.ID, Msg: tm, Version: model.Version{Time: time, Origin: "peer", OriginSeq: seq}}
+}
+
+func TestRecordChange(t *testing.T) {
+	for name, s := range testStores(t) {
+		items := testMsgs(t, 2)
+		for _, tm := range items {
+			if err := s.InsertTxtMsg(tm); err != nil {
+				t.Fatal(err)
+			}
+		}
+		// 同一秒内的多次修改, Time 也必须递增
+		for i := 0; i < 2; i++ {
+			if err := s.Edit(model.EditForm{ID: items[0].ID, Msg: "edited"}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		changes := allChanges(t, s)
+		if len(changes) != 4 {
+			t.Fatalf("%s: got %d changes; want 4", name, len(changes))
+		}
+		nodeID := s.GetConfig().NodeID
+		for i, ch := range changes {
+			if ch.Seq != uint64(i+1) || ch.Origin != nodeID || ch.OriginSeq != ch.Seq {
+				t.Errorf("%s: change %d: Seq=%d Origin=%q OriginSeq=%d", name, i, ch.Seq, ch.Origin, ch.OriginSeq)
+			}
+		}
+		if !(changes[0].Time < changes[2].Time && changes[2].Time < changes[3].Time) {
+			t.Errorf("%s: the versions of %s are not increasing: %d, %d, %d",
+				name, items[0].ID, changes[0].Time, changes[2].Time, changes[3].Time)
+		}
+	}
+}
+
+func TestApplyChange(t *testing.T) {
+	for name, s := range testStores(t) {
+		items := testMsgs(t, 2)
+		tm := items[0]
+		apply := func(step string, want int, changes ...Change) {
+			t.Helper()
+			n, err := s.ApplyChanges(changes)
+			if err != nil || n != want {
+				t.Errorf("%s: %s: applied %d, %v; want %d", name, step, n, err, want)
+			}
+		}
+		msgOf := func(id string) string {
+			got, err := s.GetByID(id)
+			if errors.Is(err, ErrNoResult) {
+				return "(none)"
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			return got.Msg + "|" + got.Alias
+		}
+
+		apply("insert", 1, remote(model.OpInsert, tm, 100, 1))
+		edited := tm
+		edited.Msg = "edited"
+		apply("edit", 1, remote(model.OpEdit, edited, 200, 2))
+		apply("same change again", 0, remote(model.OpEdit, edited, 200, 2))
+		apply("older change", 0, remote(model.OpEdit, tm, 150, 3))
+		if got := msgOf(tm.ID); got != "edited|" {
+			t.Errorf("%s: after edits: %s", name, got)
+		}
+
+		// 本服务器自己的修改记录不再应用
+		own := remote(model.OpDelete, tm, 300, 4)
+		own.Origin = s.GetConfig().NodeID
+		apply("own change", 0, own)
+
+		// 别名冲突时，Version 较新的一方保留别名
+		if err := s.InsertTxtMsg(items[1]); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.UpdateAlias("t1", "a"); err != nil {
+			t.Fatal(err)
+		}
+		aliased := edited
+		aliased.Alias = "a"
+		apply("older alias", 1, remote(model.OpAlias, aliased, 210, 5))
+		if got := msgOf(tm.ID) + " " + msgOf(items[1].ID); got != "edited| m1|a" {
+			t.Errorf("%s: after older alias: %s", name, got)
+		}
+
+		apply("delete", 1, remote(model.OpDelete, tm, 1<<40, 6))
+		if got := msgOf(tm.ID); got != "(none)" {
+			t.Errorf("%s: after delete: %s", name, got)
+		}
+		// 删除之后，较旧的修改不会使消息复活
+		apply("edit after delete", 0, remote(model.OpEdit, edited, 250, 7))
+
+		var origins []string
+		for _, ch := range allChanges(t, s) {
+			origins = append(origins, string(ch.Op)+"@"+ch.Origin)
+		}
+		nodeID := s.GetConfig().NodeID
+		want := []string{"insert@peer", "edit@peer", "insert@" + nodeID, "alias@" + nodeID, "alias@peer", "delete@peer"}
+		if !reflect.DeepEqual(origins, want) {
+			t.Errorf("%s: change log %v; want %v", name, origins, want)
+		}
+	}
+}
+
+// 超出暂存消息数量上限而被删除的消息必须记录为 OpDelete, 否则 peer 上的消息不会被删除。
+func TestEvictionChange(t *testing.T) {
+	for name, s := range testStores(t) {
+		config := s.GetConfig()
+		cf := config.ToConfigForm()
+		cf.TempLimit = 2
+		if _, err := s.UpdateConfig(cf); err != nil {
+			t.Fatal(err)
+		}
+		items := testMsgs(t, 3)
+		for _, tm := range items {
+			if err := s.InsertTxtMsg(tm); err != nil {
+				t.Fatal(err)
+			}
+		}
+		changes := allChanges(t, s)
+		if len(changes) != 4 {
+			t.Fatalf("%s: got %d changes; want 4", name, len(changes))
+		}
+		evicted, inserted := changes[2], changes[3]
+		if evicted.Op != model.OpDelete || evicted.ID != items[0].ID || evicted.Msg.Msg != items[0].Msg {
+			t.Errorf("%s: got %s %s %q; want delete %s", name, evicted.Op, evicted.ID, evicted.Msg.Msg, items[0].ID)
+		}
+		if inserted.Op != model.OpInsert || inserted.ID != items[2].ID {
+			t.Errorf("%s: got %s %s; want insert %s", name, inserted.Op, inserted.ID, items[2].ID)
+		}
+
+		// 应用到另一台服务器后，两边的暂存消息相同
+		peer := NewMemDB()
+		if _, err := peer.ApplyChanges(changes); err != nil {
+			t.Fatal(err)
+		}
+		want, _ := s.CliGetTxtMsg(temp_bucket, 1, 10)
+		got, _ := peer.CliGetTxtMsg(temp_bucket, 1, 10)
+		if !reflect.DeepEqual(summary(got), summary(want)) {
+			t.Errorf("%s: peer has %v; want %v", name, summary(got), summary(want))
+		}
+	}
+}
+
+func TestTrimChanges(t *testing.T) {
+	for name, s := range testStores(t) {
+		for _, tm := range testMsgs(t, 5) {
+			if err := s.InsertTxtMsg(tm); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if n, err := s.TrimChanges(10); n != 0 || err != nil {
+			t.Errorf("%s: TrimChanges(10) = %d, %v; want 0", name, n, err)
+		}
+		if n, err := s.TrimChanges(2); n != 3 || err != nil {
+			t.Errorf("%s: TrimChanges(2) = %d, %v; want 3", name, n, err)
+		}
+		for _, tc := range []struct {
+			since uint64
+			want  []uint64
+			err   error
+		}{
+			{0, nil, ErrChangesTrimmed}, // 新的 peer 应改用 Snapshot
+			{2, nil, ErrChangesTrimmed},
+			{3, []uint64{4, 5}, nil},
+			{5, nil, nil},
+		} {
+			changes, err := s.ChangesSince(tc.since, 10)
+			var seqs []uint64
+			for _, ch := range changes {
+				seqs = append(seqs, ch.Seq)
+			}
+			if !errors.Is(err, tc.err) || !reflect.DeepEqual(seqs, tc.want) {
+				t.Errorf("%s: ChangesSince(%d) = %v, %v; want %v, %v", name, tc.since, seqs, err, tc.want, tc.err)
+			}
+		}
+	}
+}
+
+// 新的 peer 通过 Snapshot 获得全部消息，即使旧的修改记录已被删除。
+func TestSnapshot(t *testing.T) {
+	for name, s := range testStores(t) {
+		items := testMsgs(t, 3)
+		for _, tm := range items {
+			if err := s.InsertTxtMsg(tm); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if _, err := s.ToggleCat(items[0]); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.UpdateAlias("t1", "a"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := s.TrimChanges(1); err != nil {
+			t.Fatal(err)
+		}
+		changes, last, err := s.Snapshot()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(changes) != 3 || last != 5 {
+			t.Fatalf("%s: got %d changes, last %d; want 3, 5", name, len(changes), last)
+		}
+
+		peer := NewMemDB()
+		if n, err := peer.ApplyChanges(changes); n != 3 || err != nil {
+			t.Fatalf("%s: applied %d, %v; want 3", name, n, err)
+		}
+		for _, bucket := range []string{temp_bucket, perm_bucket} {
+			want, _ := s.CliGetTxtMsg(bucket, 1, 10)
+			got, _ := peer.CliGetTxtMsg(bucket, 1, 10)
+			if !reflect.DeepEqual(summary(got), summary(want)) {
+				t.Errorf("%s: peer %s has %v; want %v", name, bucket, summary(got), summary(want))
+			}
+		}
+
+		// 之后的修改从 last 开始获取
+		if err := s.Edit(model.EditForm{ID: items[1].ID, Msg: "edited"}); err != nil {
+			t.Fatal(err)
+		}
+		more, err := s.ChangesSince(last, 10)
+		if err != nil || len(more) != 1 || more[0].Op != model.OpEdit {
+			t.Fatalf("%s: ChangesSince(%d) = %d changes, %v; want 1 edit", name, last, len(more), err)
+		}
+		if n, err := peer.ApplyChanges(more); n != 1 || err != nil {
+			t.Errorf("%s: applied %d, %v; want 1", name, n, err)
+		}
+		if got, _ := peer.GetByID(items[1].ID); got.Msg != "edited" {
+			t.Errorf("%s: peer has %q; want edited", name, got.Msg)
+		}
+	}
+}
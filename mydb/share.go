@@ -0,0 +1,267 @@
+package mydb
+
+import (
+	"encoding/base64"
+	"errors"
+	"sort"
+
+	"github.com/ahui2016/txt/model"
+	"github.com/ahui2016/txt/util"
+	"github.com/vmihailenco/msgpack/v5"
+	bolt "go.etcd.io/bbolt"
+	"golang.org/x/crypto/bcrypt"
+)
+
+type Share = model.Share
+
+var ErrShareExpired = errors.New("the share link is expired")
+var ErrSharePassword = errors.New("wrong share password")
+
+const shareTokenSize = 12
+
+// NewShare 生成一个新的分享链接 (尚未保存), password 为空表示不需要密码。
+func NewShare(msgID string, expires int64, maxViews int, password string) (share Share, err error) {
+	share = Share{
+		Token:    base64.RawURLEncoding.EncodeToString(util.RandomBytes(shareTokenSize)),
+		MsgID:    msgID,
+		Created:  util.TimeNow(),
+		Expires:  expires,
+		MaxViews: maxViews,
+	}
+	if password != "" {
+		hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+		if err != nil {
+			return share, err
+		}
+		share.Password = string(hash)
+		share.HasPassword = true
+	}
+	return
+}
+
+// CheckSharePassword 检查分享链接的密码 (不需要密码时总是通过)。
+func CheckSharePassword(share Share, password string) error {
+	if !share.HasPassword {
+		return nil
+	}
+	if bcrypt.CompareHashAndPassword([]byte(share.Password), []byte(password)) != nil {
+		return ErrSharePassword
+	}
+	return nil
+}
+
+func sortShares(shares []Share) {
+	sort.Slice(shares, func(i, j int) bool {
+		return shares[i].Created > shares[j].Created
+	})
+}
+
+// PutShare 保存分享链接，所分享的消息必须存在。
+func (db *DB) PutShare(share Share) error {
+	return db.DB.Update(func(tx *bolt.Tx) error {
+		if _, err := txGetByID(tx, share.MsgID); err != nil {
+			return err
+		}
+		return txPutObject(tx, share_bucket, share.Token, share)
+	})
+}
+
+func txGetShare(tx *bolt.Tx, token string) (share Share, err error) {
+	data, err := txGetBytes(tx, share_bucket, token)
+	if err != nil {
+		return
+	}
+	err = msgpack.Unmarshal(data, &share)
+	return
+}
+
+func (db *DB) GetShare(token string) (share Share, err error) {
+	err = db.DB.View(func(tx *bolt.Tx) error {
+		share, err = txGetShare(tx, token)
+		return err
+	})
+	return
+}
+
+// GetShares 返回全部分享链接 (从新到旧)。
+func (db *DB) GetShares() (shares []Share, err error) {
+	err = db.DB.View(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(share_bucket)).ForEach(func(_, v []byte) error {
+			var share Share
+			if err := msgpack.Unmarshal(v, &share); err != nil {
+				return err
+			}
+			shares = append(shares, share)
+			return nil
+		})
+	})
+	sortShares(shares)
+	return
+}
+
+func (db *DB) DeleteShare(token string) error {
+	return db.DB.Update(func(tx *bolt.Tx) error {
+		if _, err := txGetShare(tx, token); err != nil {
+			return err
+		}
+		return tx.Bucket([]byte(share_bucket)).Delete([]byte(token))
+	})
+}
+
+// ViewShare 返回分享的消息，并增加查看次数。
+// 只读模式下无法记录查看次数，因此限制了查看次数的分享链接不可用。
+func (db *DB) ViewShare(token string) (tm TxtMsg, err error) {
+	view := func(tx *bolt.Tx) error {
+		share, err := txGetShare(tx, token)
+		if err != nil {
+			return err
+		}
+		if share.Expired(util.TimeNow()) {
+			return ErrShareExpired
+		}
+		if tm, err = txGetByID(tx, share.MsgID); err != nil {
+			return err
+		}
+		if !tx.Writable() {
+			if share.MaxViews > 0 {
+				return errors.New("read-only mode, cannot count views")
+			}
+			return nil
+		}
+		share.Views++
+		return txPutObject(tx, share_bucket, share.Token, share)
+	}
+	if db.DB.IsReadOnly() {
+		err = db.DB.View(view)
+	} else {
+		err = db.DB.Update(view)
+	}
+	return
+}
+
+func txMoveShares(tx *bolt.Tx, oldID, newID string) error {
+	b := tx.Bucket([]byte(share_bucket))
+	var moved []Share
+	err := b.ForEach(func(_, v []byte) error {
+		var share Share
+		if err := msgpack.Unmarshal(v, &share); err != nil {
+			return err
+		}
+		if share.MsgID == oldID {
+			share.MsgID = newID
+			moved = append(moved, share)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, share := range moved {
+		if err := bucketPutObject(b, share.Token, share); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func txDeleteShares(tx *bolt.Tx, msgID string) error {
+	b := tx.Bucket([]byte(share_bucket))
+	var tokens []string
+	err := b.ForEach(func(k, v []byte) error {
+		var share Share
+		if err := msgpack.Unmarshal(v, &share); err != nil {
+			return err
+		}
+		if share.MsgID == msgID {
+			tokens = append(tokens, string(k))
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, token := range tokens {
+		if err := b.Delete([]byte(token)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (db *MemDB) PutShare(share Share) error {
+	db.Lock()
+	defer db.Unlock()
+	if _, err := db.getByID(share.MsgID); err != nil {
+		return err
+	}
+	db.shares[share.Token] = share
+	return nil
+}
+
+func (db *MemDB) GetShare(token string) (Share, error) {
+	db.RLock()
+	defer db.RUnlock()
+	share, ok := db.shares[token]
+	if !ok {
+		return share, ErrNoResult
+	}
+	return share, nil
+}
+
+func (db *MemDB) GetShares() (shares []Share, err error) {
+	db.RLock()
+	defer db.RUnlock()
+	for _, share := range db.shares {
+		shares = append(shares, share)
+	}
+	sortShares(shares)
+	return
+}
+
+func (db *MemDB) DeleteShare(token string) error {
+	db.Lock()
+	defer db.Unlock()
+	if _, ok := db.shares[token]; !ok {
+		return ErrNoResult
+	}
+	delete(db.shares, token)
+	return nil
+}
+
+func (db *MemDB) ViewShare(token string) (tm TxtMsg, err error) {
+	db.Lock()
+	defer db.Unlock()
+	share, ok := db.shares[token]
+	if !ok {
+		return tm, ErrNoResult
+	}
+	if share.Expired(util.TimeNow()) {
+		return tm, ErrShareExpired
+	}
+	if tm, err = db.getByID(share.MsgID); err != nil {
+		return
+	}
+	share.Views++
+	db.shares[token] = share
+	return
+}
+
+// moveShares 调用前必须已取得写锁。
+func (db *MemDB) moveShares(oldID, newID string) error {
+	for token, share := range db.shares {
+		if share.MsgID == oldID {
+			share.MsgID = newID
+			db.shares[token] = share
+		}
+	}
+	return nil
+}
+
+func (db *MemDB) deleteShares(msgID string) error {
+	for token, share := range db.shares {
+		if share.MsgID == msgID {
+			delete(db.shares, token)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,133 @@
+package mydb
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/ahui2016/txt/model"
+	"github.com/ahui2016/txt/util"
+	"github.com/vmihailenco/msgpack/v5"
+	bolt "go.etcd.io/bbolt"
+)
+
+type SSHKey = model.SSHKey
+
+func checkSSHKey(key SSHKey) error {
+	if key.Fingerprint == "" || key.PublicKey == "" {
+		return fmt.Errorf("the public key is empty")
+	}
+	if len(key.Name) > 64 {
+		return fmt.Errorf("the key name is too long")
+	}
+	return nil
+}
+
+func sortSSHKeys(keys []SSHKey) {
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].Created < keys[j].Created
+	})
+}
+
+// PutSSHKey 登记或更新一个 SSH 公钥，更新时保留原来的登记时间。
+func (db *DB) PutSSHKey(key SSHKey) error {
+	if err := checkSSHKey(key); err != nil {
+		return err
+	}
+	return db.DB.Update(func(tx *bolt.Tx) error {
+		old, err := txGetSSHKey(tx, key.Fingerprint)
+		if err != nil && err != ErrNoResult {
+			return err
+		}
+		key.Created = old.Created
+		if err == ErrNoResult {
+			key.Created = util.TimeNow()
+		}
+		return txPutObject(tx, sshkey_bucket, key.Fingerprint, key)
+	})
+}
+
+func txGetSSHKey(tx *bolt.Tx, fingerprint string) (key SSHKey, err error) {
+	data, err := txGetBytes(tx, sshkey_bucket, fingerprint)
+	if err != nil {
+		return
+	}
+	err = msgpack.Unmarshal(data, &key)
+	return
+}
+
+func (db *DB) GetSSHKey(fingerprint string) (key SSHKey, err error) {
+	err = db.DB.View(func(tx *bolt.Tx) error {
+		key, err = txGetSSHKey(tx, fingerprint)
+		return err
+	})
+	return
+}
+
+func (db *DB) GetSSHKeys() (keys []SSHKey, err error) {
+	err = db.DB.View(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(sshkey_bucket)).ForEach(func(_, v []byte) error {
+			var key SSHKey
+			if err := msgpack.Unmarshal(v, &key); err != nil {
+				return err
+			}
+			keys = append(keys, key)
+			return nil
+		})
+	})
+	sortSSHKeys(keys)
+	return
+}
+
+func (db *DB) DeleteSSHKey(fingerprint string) error {
+	return db.DB.Update(func(tx *bolt.Tx) error {
+		if _, err := txGetSSHKey(tx, fingerprint); err != nil {
+			return err
+		}
+		return tx.Bucket([]byte(sshkey_bucket)).Delete([]byte(fingerprint))
+	})
+}
+
+func (db *MemDB) PutSSHKey(key SSHKey) error {
+	if err := checkSSHKey(key); err != nil {
+		return err
+	}
+	db.Lock()
+	defer db.Unlock()
+	if old, ok := db.sshKeys[key.Fingerprint]; ok {
+		key.Created = old.Created
+	} else {
+		key.Created = util.TimeNow()
+	}
+	db.sshKeys[key.Fingerprint] = key
+	return nil
+}
+
+func (db *MemDB) GetSSHKey(fingerprint string) (SSHKey, error) {
+	db.RLock()
+	defer db.RUnlock()
+	key, ok := db.sshKeys[fingerprint]
+	if !ok {
+		return key, ErrNoResult
+	}
+	return key, nil
+}
+
+func (db *MemDB) GetSSHKeys() (keys []SSHKey, err error) {
+	db.RLock()
+	defer db.RUnlock()
+	for _, key := range db.sshKeys {
+		keys = append(keys, key)
+	}
+	sortSSHKeys(keys)
+	return
+}
+
+func (db *MemDB) DeleteSSHKey(fingerprint string) error {
+	db.Lock()
+	defer db.Unlock()
+	if _, ok := db.sshKeys[fingerprint]; !ok {
+		return ErrNoResult
+	}
+	delete(db.sshKeys, fingerprint)
+	return nil
+}
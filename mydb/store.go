@@ -0,0 +1,176 @@
+package mydb
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/ahui2016/txt/model"
+	"github.com/ahui2016/txt/util"
+)
+
+// Store 包含 handlers 需要用到的全部数据库操作。
+// 目前有两种实现: DB (基于 bolt) 与 MemDB (纯内存)。
+type Store interface {
+	Close() error
+	GetConfig() Config
+	CheckKey(key string) error
+	UpdateConfig(cf model.ConfigForm) (warning string, err error)
+	GenNewKey() error
+	ChangePwd(oldPwd, newPwd string) error
+	InitPassword(pwd string) error
+
+	NewTxtMsg(msg string) (TxtMsg, error)
+	InsertTxtMsg(tm TxtMsg) error
+	DeleteTxtMsg(id string) error
+	CliDeleteTxtMsg(a_or_i string) error
+	GetByID(id string) (TxtMsg, error)
+	GetByAliasIndex(a_or_i string) (TxtMsg, error)
+	ToggleCat(tm TxtMsg) (TxtMsg, error)
+	Edit(form model.EditForm) error
+	UpdateAlias(a_or_i, newAlias string) error
+
+	GetRecentItems(limit int) ([]TxtMsg, error)
+	GetMoreItems(bucket, start string, limit int) ([]TxtMsg, error)
+	CliGetTxtMsg(bucket string, index, limit int) ([]TxtMsg, error)
+	GetAllAliases() ([]model.Alias, error)
+	SearchTxtMsg(keyword string, buckets []string) ([]TxtMsg, error)
+
+	ChangesSince(since uint64, limit int) ([]Change, error)
+	LastRecorded() (int64, error)
+	Snapshot() (changes []Change, last uint64, err error)
+	TrimChanges(keep int) (int, error)
+	ApplyChanges(changes []Change) (int, error)
+	GetPeerCursor(peer string) (uint64, error)
+	SetPeerCursor(peer string, seq uint64) error
+	Subscribe() (<-chan Change, func())
+
+	PutDevice(device Device) error
+	GetDevice(name string) (Device, error)
+	GetDevices() ([]Device, error)
+	DeleteDevice(name string) error
+
+	PutShare(share Share) error
+	GetShare(token string) (Share, error)
+	GetShares() ([]Share, error)
+	DeleteShare(token string) error
+	ViewShare(token string) (TxtMsg, error)
+
+	PutWebhook(hook Webhook) error
+	GetWebhooks() ([]Webhook, error)
+	DeleteWebhook(id string) error
+	DueDeliveries(now int64, limit int) ([]Delivery, error)
+	UpdateDelivery(d Delivery) error
+	DeleteDelivery(id uint64) error
+	GetDeliveries(hookID string, limit int) ([]Delivery, error)
+
+	PutSSHKey(key SSHKey) error
+	GetSSHKey(fingerprint string) (SSHKey, error)
+	GetSSHKeys() ([]SSHKey, error)
+	DeleteSSHKey(fingerprint string) error
+}
+
+// 确保两种实现都满足 Store 接口。
+var (
+	_ Store = (*DB)(nil)
+	_ Store = (*MemDB)(nil)
+)
+
+func checkKey(config Config, key string) error {
+	if key != config.Key {
+		return ErrWrongKey
+	}
+	if util.TimeNow() > config.KeyStarts+config.KeyMaxAge {
+		return ErrKeyExpired
+	}
+	return nil
+}
+
+func newTxtMsg(config Config, msg string) (TxtMsg, error) {
+	if len(msg) > config.MsgSizeLimit {
+		// 不使用 util.WrapErrors, 因为它只能 wrap 最后一个错误, 而调用者需要 errors.Is(err, ErrMsgTooLong).
+		return TxtMsg{}, fmt.Errorf("%w | size: %d, limit: %d", ErrMsgTooLong, len(msg), config.MsgSizeLimit)
+	}
+	return model.NewTxtMsg(msg, config.TimeOffset)
+}
+
+// parseIndex 把流水号 (比如 "t1", "P12") 拆分为 bucket 与数字。
+// 调用前应先用 checkAlias 确认 a_or_i 不是别名。
+func parseIndex(a_or_i string) (bucket string, i int) {
+	index := strings.ToUpper(a_or_i)
+	bucket = temp_bucket
+	// index 的头部要么是 T, 要么是 P
+	if index[0] == 'P' {
+		bucket = perm_bucket
+	}
+	// index 的尾部是数字
+	i, _ = strconv.Atoi(index[1:])
+	return
+}
+
+// applyConfigForm 把 ConfigForm 中的有效项目更新到 config, 无效项目则忽略。
+func applyConfigForm(config Config, cf model.ConfigForm) (Config, string) {
+	var ignore []string
+
+	maxAge := cf.KeyMaxAge * day
+	if maxAge < 1 {
+		ignore = append(ignore, "key_max_age")
+	} else {
+		config.KeyMaxAge = cf.KeyMaxAge * day
+	}
+
+	if cf.MsgSizeLimit < 256 {
+		ignore = append(ignore, "msg_size_limit")
+	} else {
+		config.MsgSizeLimit = cf.MsgSizeLimit
+	}
+
+	if cf.TempLimit < 1 {
+		ignore = append(ignore, "temp_msg_limit")
+	} else {
+		config.TempLimit = cf.TempLimit
+	}
+
+	if cf.EveryPageLimit < 1 {
+		ignore = append(ignore, "page_limit")
+	} else {
+		config.EveryPageLimit = cf.EveryPageLimit
+	}
+
+	if _, err := model.DateID(cf.TimeOffset); err != nil {
+		ignore = append(ignore, "timeone_offset")
+	} else {
+		config.TimeOffset = cf.TimeOffset
+	}
+
+	if len(ignore) > 0 {
+		return config, "ignore: " + strings.Join(ignore, ", ")
+	}
+	return config, ""
+}
+
+func checkNewPwd(config Config, oldPwd, newPwd string) error {
+	if oldPwd == "" {
+		return fmt.Errorf("the current password is empty")
+	}
+	if newPwd == "" {
+		return fmt.Errorf("the new password is empty")
+	}
+	if newPwd == oldPwd {
+		return fmt.Errorf("the two passwords are the same")
+	}
+	if config.Password != oldPwd {
+		return fmt.Errorf("the current password is wrong")
+	}
+	return nil
+}
+
+func checkInitPwd(config Config, pwd string) error {
+	if config.Password != "" && config.Password != DefaultPassword {
+		return ErrPasswordSet
+	}
+	if pwd == "" {
+		return fmt.Errorf("the new password is empty")
+	}
+	return nil
+}
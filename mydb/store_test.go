@@ -0,0 +1,220 @@
+package mydb
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/ahui2016/txt/model"
+	bolt "go.etcd.io/bbolt"
+)
+
+// openTestDB 在临时文件夹里打开一个 bolt 数据库。
+func openTestDB(t *testing.T) *DB {
+	t.Helper()
+	db := new(DB)
+	if err := db.Open(filepath.Join(t.TempDir(), "test.bolt")); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// testMsgs 返回 n 条暂存消息，ID 依次相隔一秒 (不使用 NewTxtMsg, 以免每条消息暂停一秒)。
+func testMsgs(t *testing.T, n int) []TxtMsg {
+	t.Helper()
+	base := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	items := make([]TxtMsg, n)
+	for i := range items {
+		id, err := model.DateIDAt(base.Add(time.Duration(i)*time.Second), BeijingTime)
+		if err != nil {
+			t.Fatal(err)
+		}
+		items[i] = TxtMsg{ID: id, Msg: fmt.Sprintf("m%d", i), Cat: model.CatTemp}
+	}
+	return items
+}
+
+// summary 只保留与实现无关的字段 (ToggleCat 生成的新 ID 取决于当时的时间)。
+func summary(items []TxtMsg) (s []string) {
+	for _, tm := range items {
+		s = append(s, fmt.Sprintf("%s|%s|%s|%d", tm.Msg, tm.Alias, tm.Cat, tm.Index))
+	}
+	return
+}
+
+// storeScript 对 s 执行一系列操作，返回每一步的结果，供比较两种实现。
+func storeScript(t *testing.T, s Store) (results []string) {
+	t.Helper()
+	record := func(step string, v ...interface{}) {
+		results = append(results, step+": "+fmt.Sprint(v...))
+	}
+	items := testMsgs(t, 8)
+	for _, tm := range items[:6] {
+		if err := s.InsertTxtMsg(tm); err != nil {
+			t.Fatal(err)
+		}
+	}
+	record("same as last", errors.Is(s.InsertTxtMsg(TxtMsg{ID: "x", Msg: "m5", Cat: model.CatTemp}), ErrSameAsLast))
+
+	for _, q := range [][2]int{{1, 3}, {2, 2}, {5, 10}, {0, 2}, {7, 3}} {
+		got, err := s.CliGetTxtMsg(temp_bucket, q[0], q[1])
+		record(fmt.Sprint("cli get ", q), summary(got), err)
+	}
+	got, err := s.GetMoreItems(temp_bucket, items[3].ID, 2)
+	record("more items", summary(got), err)
+
+	record("alias", s.UpdateAlias("t1", "a"))
+	record("bad alias", errors.Is(s.UpdateAlias("t2", "T5"), ErrBadAlias))
+	record("alias exists", errors.Is(s.UpdateAlias("t2", "a"), ErrKeyExists))
+	tm, err := s.GetByAliasIndex("A")
+	record("get alias", summary([]TxtMsg{tm}), err)
+	record("edit", s.Edit(model.EditForm{ID: items[2].ID, Alias: "b", Msg: "edited"}))
+	aliases, err := s.GetAllAliases()
+	record("aliases", aliases, err)
+
+	toggled, err := s.ToggleCat(items[3])
+	record("toggle", toggled.Msg, toggled.Cat, err)
+	for _, bucket := range []string{temp_bucket, perm_bucket} {
+		got, err := s.CliGetTxtMsg(bucket, 1, 10)
+		record("after toggle "+bucket, summary(got), err)
+	}
+
+	record("delete", s.DeleteTxtMsg(items[0].ID))
+	record("cli delete", s.CliDeleteTxtMsg("t1"))
+	_, err = s.GetByAliasIndex("a")
+	record("deleted alias", errors.Is(err, ErrNoResult))
+	_, err = s.GetByAliasIndex("t99")
+	record("no index", errors.Is(err, ErrNoResult))
+	record("delete twice", errors.Is(s.DeleteTxtMsg(items[0].ID), ErrNoResult))
+
+	got, err = s.SearchTxtMsg("M", nil)
+	record("search", summary(got), err)
+	got, err = s.SearchTxtMsg("m", []string{perm_bucket})
+	record("search perm", summary(got), err)
+	got, err = s.GetRecentItems(2)
+	record("recent", summary(got), err)
+
+	// 暂存消息的数量上限，插入时删除最旧的消息
+	config := s.GetConfig()
+	cf := config.ToConfigForm()
+	cf.TempLimit = 3
+	_, err = s.UpdateConfig(cf)
+	record("temp limit", err)
+	for _, tm := range items[6:] {
+		record("insert", s.InsertTxtMsg(tm))
+	}
+	got, err = s.CliGetTxtMsg(temp_bucket, 1, 10)
+	record("after limit", summary(got), err)
+
+	changes, err := s.ChangesSince(0, 100)
+	var ops []model.ChangeOp
+	for _, ch := range changes {
+		ops = append(ops, ch.Op)
+	}
+	record("changes", ops, err)
+	return
+}
+
+func TestStoreParity(t *testing.T) {
+	boltResults := storeScript(t, openTestDB(t))
+	memResults := storeScript(t, NewMemDB())
+	if len(boltResults) != len(memResults) {
+		t.Fatalf("got %d results from DB, %d from MemDB", len(boltResults), len(memResults))
+	}
+	for i := range boltResults {
+		if boltResults[i] != memResults[i] {
+			t.Errorf("\n  DB:    %s\n  MemDB: %s", boltResults[i], memResults[i])
+		}
+	}
+}
+
+func TestCliGetTxtMsg(t *testing.T) {
+	for name, s := range map[string]Store{"DB": openTestDB(t), "MemDB": NewMemDB()} {
+		for _, tm := range testMsgs(t, 5) {
+			if err := s.InsertTxtMsg(tm); err != nil {
+				t.Fatal(err)
+			}
+		}
+		// 从流水号 index 开始，从新到旧
+		got, err := s.CliGetTxtMsg(temp_bucket, 2, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := []string{"m3||Temporary|2", "m2||Temporary|3"}; !reflect.DeepEqual(summary(got), want) {
+			t.Errorf("%s: CliGetTxtMsg(temp, 2, 2) = %v; want %v", name, summary(got), want)
+		}
+	}
+}
+
+func TestOpenReadOnly(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "old.bolt")
+	// 模拟旧版本的数据库：只有部分 bucket
+	old, err := bolt.Open(path, 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = old.Update(func(tx *bolt.Tx) error {
+		for _, name := range []string{config_bucket, temp_bucket, perm_bucket, alias_bucket} {
+			if _, err := tx.CreateBucket([]byte(name)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	old.Close()
+
+	db := new(DB)
+	if err := db.OpenReadOnly(path); !errors.Is(err, ErrOldDatabase) {
+		t.Fatalf("OpenReadOnly(old database) = %v; want ErrOldDatabase", err)
+	}
+
+	// 以普通方式打开一次之后即可
+	if err := db.Open(path); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+	if err := db.OpenReadOnly(path); err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, err := db.ChangesSince(0, 10); err != nil {
+		t.Error(err)
+	}
+	if _, err := db.GetShares(); err != nil {
+		t.Error(err)
+	}
+}
+
+// ToggleCat 生成新 ID 时会暂停一秒，此时其他读取不应被阻塞。
+func TestMemDBToggleDoesNotBlock(t *testing.T) {
+	db := NewMemDB()
+	items := testMsgs(t, 2)
+	for _, tm := range items {
+		if err := db.InsertTxtMsg(tm); err != nil {
+			t.Fatal(err)
+		}
+	}
+	done := make(chan error, 1)
+	go func() {
+		_, err := db.ToggleCat(items[0])
+		done <- err
+	}()
+	time.Sleep(100 * time.Millisecond)
+	start := time.Now()
+	if _, err := db.GetRecentItems(10); err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(start); d > 500*time.Millisecond {
+		t.Errorf("GetRecentItems took %v during ToggleCat", d)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
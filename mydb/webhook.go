@@ -0,0 +1,323 @@
+package mydb
+
+import (
+	"sort"
+
+	"github.com/ahui2016/txt/model"
+	"github.com/ahui2016/txt/util"
+	"github.com/vmihailenco/msgpack/v5"
+	bolt "go.etcd.io/bbolt"
+)
+
+type (
+	Webhook  = model.Webhook
+	Delivery = model.Delivery
+)
+
+// 最多保留多少条已完成 (成功或失败) 的 Delivery 作为记录
+const deliveryLogLimit = 500
+
+// newDelivery 返回一个立即可发送的 Delivery (ID 由调用者分配)。
+func newDelivery(hook Webhook, ch Change) Delivery {
+	now := util.TimeNow()
+	return Delivery{
+		HookID:  hook.ID,
+		Change:  ch,
+		Status:  model.DeliveryPending,
+		NextTry: now,
+		Updated: now,
+	}
+}
+
+func (db *DB) PutWebhook(hook Webhook) error {
+	return db.DB.Update(func(tx *bolt.Tx) error {
+		return txPutObject(tx, webhook_bucket, hook.ID, hook)
+	})
+}
+
+func txGetWebhooks(tx *bolt.Tx) (hooks []Webhook, err error) {
+	err = tx.Bucket([]byte(webhook_bucket)).ForEach(func(_, v []byte) error {
+		var hook Webhook
+		if err := msgpack.Unmarshal(v, &hook); err != nil {
+			return err
+		}
+		hooks = append(hooks, hook)
+		return nil
+	})
+	return
+}
+
+func (db *DB) GetWebhooks() (hooks []Webhook, err error) {
+	err = db.DB.View(func(tx *bolt.Tx) error {
+		hooks, err = txGetWebhooks(tx)
+		return err
+	})
+	sortWebhooks(hooks)
+	return
+}
+
+func sortWebhooks(hooks []Webhook) {
+	sort.Slice(hooks, func(i, j int) bool {
+		return hooks[i].Created < hooks[j].Created
+	})
+}
+
+// DeleteWebhook 删除 Webhook 及其尚未发送的 Delivery.
+func (db *DB) DeleteWebhook(id string) error {
+	return db.DB.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(webhook_bucket))
+		if b.Get([]byte(id)) == nil {
+			return ErrNoResult
+		}
+		if err := b.Delete([]byte(id)); err != nil {
+			return err
+		}
+		return txDeleteDeliveries(tx, func(d Delivery) bool {
+			return d.HookID == id && d.Status == model.DeliveryPending
+		})
+	})
+}
+
+// txDeleteDeliveries 删除满足 match 的 Delivery.
+func txDeleteDeliveries(tx *bolt.Tx, match func(Delivery) bool) error {
+	b := tx.Bucket([]byte(delivery_bucket))
+	var keys [][]byte
+	err := b.ForEach(func(k, v []byte) error {
+		var d Delivery
+		if err := msgpack.Unmarshal(v, &d); err != nil {
+			return err
+		}
+		if match(d) {
+			keys = append(keys, k)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, k := range keys {
+		if err := b.Delete(k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DueDeliveries 返回到了发送时间的 Delivery (从旧到新)，最多 limit 条。
+func (db *DB) DueDeliveries(now int64, limit int) (due []Delivery, err error) {
+	err = db.DB.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket([]byte(delivery_bucket)).Cursor()
+		for k, v := c.First(); k != nil && len(due) < limit; k, v = c.Next() {
+			var d Delivery
+			if err := msgpack.Unmarshal(v, &d); err != nil {
+				return err
+			}
+			if d.Status == model.DeliveryPending && d.NextTry <= now {
+				due = append(due, d)
+			}
+		}
+		return nil
+	})
+	return
+}
+
+// UpdateDelivery 保存发送结果，同时删除超出 deliveryLogLimit 的旧记录。
+func (db *DB) UpdateDelivery(d Delivery) error {
+	return db.DB.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(delivery_bucket))
+		if b.Get(itob(d.ID)) == nil {
+			return ErrNoResult // 可能 Webhook 已被删除
+		}
+		data, err := msgpack.Marshal(d)
+		if err != nil {
+			return err
+		}
+		if err := b.Put(itob(d.ID), data); err != nil {
+			return err
+		}
+		// 从新到旧数，超出上限的已完成记录都删除。
+		finished := 0
+		var old [][]byte
+		c := b.Cursor()
+		for k, v := c.Last(); k != nil; k, v = c.Prev() {
+			var d Delivery
+			if err := msgpack.Unmarshal(v, &d); err != nil {
+				return err
+			}
+			if d.Status == model.DeliveryPending {
+				continue
+			}
+			if finished++; finished > deliveryLogLimit {
+				old = append(old, k)
+			}
+		}
+		for _, k := range old {
+			if err := b.Delete(k); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// DeleteDelivery 删除一个 Delivery (例如其 Webhook 已不存在)。
+func (db *DB) DeleteDelivery(id uint64) error {
+	return db.DB.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(delivery_bucket))
+		if b.Get(itob(id)) == nil {
+			return ErrNoResult
+		}
+		return b.Delete(itob(id))
+	})
+}
+
+// GetDeliveries 返回最近的 Delivery (从新到旧), hookID 为空表示全部。
+func (db *DB) GetDeliveries(hookID string, limit int) (deliveries []Delivery, err error) {
+	err = db.DB.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket([]byte(delivery_bucket)).Cursor()
+		for k, v := c.Last(); k != nil && len(deliveries) < limit; k, v = c.Prev() {
+			var d Delivery
+			if err := msgpack.Unmarshal(v, &d); err != nil {
+				return err
+			}
+			if hookID == "" || d.HookID == hookID {
+				deliveries = append(deliveries, d)
+			}
+		}
+		return nil
+	})
+	return
+}
+
+func txQueueDeliveries(tx *bolt.Tx, ch Change) error {
+	hooks, err := txGetWebhooks(tx)
+	if err != nil {
+		return err
+	}
+	b := tx.Bucket([]byte(delivery_bucket))
+	for _, hook := range hooks {
+		if !hook.Wants(ch.Op) {
+			continue
+		}
+		d := newDelivery(hook, ch)
+		if d.ID, err = b.NextSequence(); err != nil {
+			return err
+		}
+		data, err := msgpack.Marshal(d)
+		if err != nil {
+			return err
+		}
+		if err := b.Put(itob(d.ID), data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (db *MemDB) PutWebhook(hook Webhook) error {
+	db.Lock()
+	defer db.Unlock()
+	db.webhooks[hook.ID] = hook
+	return nil
+}
+
+func (db *MemDB) GetWebhooks() (hooks []Webhook, err error) {
+	db.RLock()
+	defer db.RUnlock()
+	for _, hook := range db.webhooks {
+		hooks = append(hooks, hook)
+	}
+	sortWebhooks(hooks)
+	return
+}
+
+func (db *MemDB) DeleteWebhook(id string) error {
+	db.Lock()
+	defer db.Unlock()
+	if _, ok := db.webhooks[id]; !ok {
+		return ErrNoResult
+	}
+	delete(db.webhooks, id)
+	kept := db.deliveries[:0]
+	for _, d := range db.deliveries {
+		if d.HookID != id || d.Status != model.DeliveryPending {
+			kept = append(kept, d)
+		}
+	}
+	db.deliveries = kept
+	return nil
+}
+
+func (db *MemDB) DueDeliveries(now int64, limit int) (due []Delivery, err error) {
+	db.RLock()
+	defer db.RUnlock()
+	for _, d := range db.deliveries {
+		if len(due) >= limit {
+			break
+		}
+		if d.Status == model.DeliveryPending && d.NextTry <= now {
+			due = append(due, d)
+		}
+	}
+	return
+}
+
+func (db *MemDB) UpdateDelivery(d Delivery) error {
+	db.Lock()
+	defer db.Unlock()
+	i := sort.Search(len(db.deliveries), func(i int) bool {
+		return db.deliveries[i].ID >= d.ID
+	})
+	if i == len(db.deliveries) || db.deliveries[i].ID != d.ID {
+		return ErrNoResult
+	}
+	db.deliveries[i] = d
+
+	finished := 0
+	for i := len(db.deliveries) - 1; i >= 0; i-- {
+		if db.deliveries[i].Status == model.DeliveryPending {
+			continue
+		}
+		if finished++; finished > deliveryLogLimit {
+			db.deliveries = append(db.deliveries[:i], db.deliveries[i+1:]...)
+		}
+	}
+	return nil
+}
+
+func (db *MemDB) DeleteDelivery(id uint64) error {
+	db.Lock()
+	defer db.Unlock()
+	i := sort.Search(len(db.deliveries), func(i int) bool {
+		return db.deliveries[i].ID >= id
+	})
+	if i == len(db.deliveries) || db.deliveries[i].ID != id {
+		return ErrNoResult
+	}
+	db.deliveries = append(db.deliveries[:i], db.deliveries[i+1:]...)
+	return nil
+}
+
+func (db *MemDB) GetDeliveries(hookID string, limit int) (deliveries []Delivery, err error) {
+	db.RLock()
+	defer db.RUnlock()
+	for i := len(db.deliveries) - 1; i >= 0 && len(deliveries) < limit; i-- {
+		if d := db.deliveries[i]; hookID == "" || d.HookID == hookID {
+			deliveries = append(deliveries, d)
+		}
+	}
+	return
+}
+
+// queueDeliveries 调用前必须已取得写锁。
+func (db *MemDB) queueDeliveries(ch Change) error {
+	for _, hook := range db.webhooks {
+		if hook.Wants(ch.Op) {
+			db.deliverySeq++
+			d := newDelivery(hook, ch)
+			d.ID = db.deliverySeq
+			db.deliveries = append(db.deliveries, d)
+		}
+	}
+	return nil
+}
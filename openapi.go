@@ -0,0 +1,493 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/ahui2016/txt/model"
+	"github.com/gin-gonic/gin"
+)
+
+// 各路由的验证方式
+const (
+	authNone     = ""         // 不需要验证
+	authPassword = "password" // 表单字段 password 是主密码
+	authSession  = "session"  // 需要先登录 (cookie)
+	authKey      = "key"      // 密钥，传递方式见 keyFromRequest
+	authBearer   = "bearer"   // 与 authKey 相同，但错误响应是 APIError
+)
+
+// apiParam 是一个表单字段 (POST) 或查询参数 (GET)。
+type apiParam struct {
+	Name     string
+	Type     string // string, integer, boolean, array (元素是 string)
+	Required bool
+	Desc     string
+}
+
+// apiRoute 描述一个路由，用于生成 OpenAPI 文档。
+// 修改 main.go 里的路由时，必须同时修改 apiRoutes, 否则测试不能通过 (见 openapi_test.go)。
+type apiRoute struct {
+	Method   string
+	Path     string // gin 的格式，例如 /v2/messages/:id
+	Summary  string
+	Auth     string
+	Params   []apiParam
+	JSONBody interface{} // JSON 格式的请求内容 (仅用于 v2)
+	Status   int         // 成功时的状态码，零表示 200
+	Response interface{} // 成功时返回的内容，例如 model.TxtMsg{}, nil 表示没有内容
+	Produces string      // 非 JSON 的返回内容的 Content-Type
+	AlsoGET  bool        // 该 POST 路由也可以使用 GET (参数放在查询参数中)
+}
+
+func optStr(name, desc string) apiParam   { return apiParam{name, "string", false, desc} }
+func reqStr(name, desc string) apiParam   { return apiParam{name, "string", true, desc} }
+func optInt(name, desc string) apiParam   { return apiParam{name, "integer", false, desc} }
+func reqInt(name, desc string) apiParam   { return apiParam{name, "integer", true, desc} }
+func optBool(name, desc string) apiParam  { return apiParam{name, "boolean", false, desc} }
+func optArray(name, desc string) apiParam { return apiParam{name, "array", false, desc} }
+
+var (
+	aOrIParam   = reqStr("a_or_i", "别名或流水号，例如 t1, p2, email")
+	bucketParam = reqStr("bucket", "temporary-bucket 或 permanent-bucket")
+	deviceParam = optStr("device", "发送该消息的设备名称 (必须已登记)")
+	searchParam = []apiParam{
+		reqStr("keyword", "关键词"),
+		optArray("buckets", "在哪些 bucket 中查找，留空表示全部"),
+	}
+)
+
+var apiRoutes = []apiRoute{
+	{Method: "GET", Path: "/openapi.json", Summary: "本文档", Response: map[string]interface{}{}},
+
+	{Method: "GET", Path: "/auth/is-signed-in", Summary: "是否已登录", Response: true},
+	{Method: "POST", Path: "/auth/sign-in", Summary: "使用密钥登录",
+		Params: []apiParam{reqStr("password", "密钥")}},
+	{Method: "GET", Path: "/auth/sign-out", Summary: "退出登录"},
+	{Method: "POST", Path: "/auth/get-current-key", Summary: "获取当前密钥",
+		Auth: authPassword, Response: secretKey{}},
+	{Method: "POST", Path: "/auth/gen-new-key", Summary: "生成新密钥",
+		Auth: authPassword, Response: secretKey{}},
+	{Method: "POST", Path: "/auth/change-pwd", Summary: "更改主密码",
+		Params: []apiParam{reqStr("oldpwd", "当前主密码"), reqStr("newpwd", "新主密码")}},
+
+	{Method: "POST", Path: "/api/add", Summary: "新增暂存消息", Auth: authSession,
+		Params: []apiParam{reqStr("msg", "消息内容"), deviceParam}},
+	{Method: "GET", Path: "/api/recent-items", Summary: "最近的消息", Auth: authSession,
+		Response: []model.TxtMsg{}},
+	{Method: "POST", Path: "/api/toggle-category", Summary: "在暂存与永久之间转换", Auth: authSession,
+		Params: []apiParam{reqStr("id", "TxtMsg.ID")}},
+	{Method: "POST", Path: "/api/delete", Summary: "删除消息", Auth: authSession,
+		Params: []apiParam{reqStr("id", "TxtMsg.ID")}},
+	{Method: "POST", Path: "/api/get-by-id", Summary: "获取一条消息", Auth: authSession,
+		Params: []apiParam{reqStr("id", "TxtMsg.ID")}, Response: model.TxtMsg{}},
+	{Method: "POST", Path: "/api/edit", Summary: "修改消息内容与别名", Auth: authSession,
+		Params: []apiParam{reqStr("id", "TxtMsg.ID"), optStr("alias", "别名"), reqStr("msg", "消息内容")}},
+	{Method: "GET", Path: "/api/get-config", Summary: "获取设置", Auth: authSession,
+		Response: model.ConfigForm{}},
+	{Method: "POST", Path: "/api/update-config", Summary: "修改设置", Auth: authSession,
+		Params: []apiParam{
+			optInt("KeyMaxAge", "密钥的有效期 (天)"),
+			optInt("MsgSizeLimit", "每条消息的长度上限"),
+			optInt("TempLimit", "暂存消息条数上限"),
+			optInt("EveryPageLimit", "每页最多列出多少条消息"),
+			optStr("TimeOffset", "时区，例如 +8"),
+		}, Response: Text{}},
+	{Method: "POST", Path: "/api/get-more-items", Summary: "分页获取消息", Auth: authSession,
+		Params:   []apiParam{bucketParam, optStr("start", "从该 TxtMsg.ID 之前开始"), optInt("limit", "条数")},
+		Response: []model.TxtMsg{}},
+	{Method: "GET", Path: "/api/get-all-aliases", Summary: "全部别名", Auth: authSession,
+		Response: []model.Alias{}},
+	{Method: "POST", Path: "/api/search", Summary: "查找消息", Auth: authSession,
+		Params: searchParam, Response: []model.TxtMsg{}},
+	{Method: "GET", Path: "/api/events", Summary: "修改记录 (Server-Sent Events)", Auth: authSession,
+		Params: []apiParam{optStr("last_event_id", "补发该流水号之后的修改记录")}, Produces: "text/event-stream"},
+
+	{Method: "POST", Path: "/cli/add", Summary: "新增暂存消息", Auth: authKey,
+		Params: []apiParam{reqStr("msg", "消息内容"), deviceParam}},
+	{Method: "POST", Path: "/cli/toggle-category", Summary: "在暂存与永久之间转换", Auth: authKey,
+		Params: []apiParam{aOrIParam}, Response: model.TxtMsg{}},
+	{Method: "POST", Path: "/cli/delete", Summary: "删除消息", Auth: authKey,
+		Params: []apiParam{aOrIParam}},
+	{Method: "POST", Path: "/cli/get-by-a-or-i", Summary: "获取一条消息", Auth: authKey, AlsoGET: true,
+		Params: []apiParam{aOrIParam}, Response: model.TxtMsg{}},
+	{Method: "POST", Path: "/cli/set-alias", Summary: "设置别名 (留空表示删除别名)", Auth: authKey,
+		Params: []apiParam{aOrIParam, optStr("alias", "新别名")}},
+	{Method: "POST", Path: "/cli/get-more-items", Summary: "分页获取消息", Auth: authKey, AlsoGET: true,
+		Params:   []apiParam{bucketParam, optInt("index", "从该流水号开始"), reqInt("limit", "条数")},
+		Response: []model.TxtMsg{}},
+	{Method: "POST", Path: "/cli/get-all-aliases", Summary: "全部别名", Auth: authKey, AlsoGET: true,
+		Response: []model.Alias{}},
+	{Method: "POST", Path: "/cli/search", Summary: "查找消息", Auth: authKey, AlsoGET: true,
+		Params: searchParam, Response: []model.TxtMsg{}},
+	{Method: "POST", Path: "/cli/replicate", Summary: "供其他服务器同步的修改记录 (since 为零时返回全部消息，所需记录已删除时返回 410)", Auth: authKey,
+		Params: []apiParam{optInt("since", "流水号"), optInt("limit", "条数")}, Response: ChangeList{}},
+	{Method: "GET", Path: "/cli/changes", Summary: "流水号 since 之后的修改 (所需记录已删除时返回 410)", Auth: authKey,
+		Params: []apiParam{optInt("since", "流水号")}, Response: ChangeFeed{}},
+	{Method: "GET", Path: "/cli/events", Summary: "修改记录 (Server-Sent Events)", Auth: authKey,
+		Params: []apiParam{optStr("last_event_id", "补发该流水号之后的修改记录")}, Produces: "text/event-stream"},
+	{Method: "POST", Path: "/cli/register-device", Summary: "登记设备", Auth: authKey,
+		Params: []apiParam{
+			reqStr("device", "设备名称"),
+			optBool("skip_self", "不接收本设备发送的消息"),
+			optArray("mute", "不接收这些设备发送的消息"),
+		}, Response: DeviceRegistration{}},
+	{Method: "POST", Path: "/cli/get-devices", Summary: "全部设备", Auth: authKey, AlsoGET: true,
+		Response: []model.Device{}},
+	{Method: "POST", Path: "/cli/delete-device", Summary: "删除设备", Auth: authKey,
+		Params: []apiParam{reqStr("device", "设备名称")}},
+	{Method: "GET", Path: "/cli/clipboard", Summary: "剪贴板同步 (WebSocket, 消息格式见 WsMessage)", Auth: authKey,
+		Params: []apiParam{reqStr("device", "设备名称"), reqStr("token", "登记设备时得到的 Token")},
+		Status: http.StatusSwitchingProtocols},
+	{Method: "GET", Path: "/cli/wait", Summary: "等待下一条新的暂存消息, 超时返回 204", Auth: authKey,
+		Params:   []apiParam{optStr("after", "TxtMsg.ID, 留空表示当前最新的消息"), optInt("timeout", "秒")},
+		Response: model.TxtMsg{}},
+	{Method: "POST", Path: "/cli/share", Summary: "生成分享链接", Auth: authKey,
+		Params: []apiParam{
+			aOrIParam,
+			optStr("expires", "有效期，例如 30m, 24h, 留空表示永不过期"),
+			optInt("max_views", "最多可查看多少次，零表示不限"),
+			optStr("share_password", "查看时需要输入的密码"),
+		}, Response: ShareLink{}},
+	{Method: "POST", Path: "/cli/get-shares", Summary: "全部分享链接", Auth: authKey, AlsoGET: true,
+		Response: []ShareLink{}},
+	{Method: "POST", Path: "/cli/revoke-share", Summary: "撤销分享链接", Auth: authKey,
+		Params: []apiParam{reqStr("token", "Share.Token")}},
+	{Method: "POST", Path: "/cli/add-webhook", Summary: "添加 Webhook (内容见 WebhookPayload)", Auth: authKey,
+		Params: []apiParam{
+			reqStr("url", "接收通知的网址"),
+			optArray("events", "insert, edit, alias, toggle, delete, 留空表示全部"),
+			optStr("secret", "签名密钥 (X-Txt-Signature), 留空则自动生成"),
+		}, Response: WebhookCreated{}},
+	{Method: "POST", Path: "/cli/get-webhooks", Summary: "全部 Webhook", Auth: authKey, AlsoGET: true,
+		Response: []model.Webhook{}},
+	{Method: "POST", Path: "/cli/delete-webhook", Summary: "删除 Webhook", Auth: authKey,
+		Params: []apiParam{reqStr("id", "Webhook.ID")}},
+	{Method: "POST", Path: "/cli/get-deliveries", Summary: "最近的通知记录", Auth: authKey, AlsoGET: true,
+		Params:   []apiParam{optStr("hook_id", "Webhook.ID, 留空表示全部"), optInt("limit", "条数")},
+		Response: []model.Delivery{}},
+	{Method: "POST", Path: "/cli/add-ssh-key", Summary: "登记 SSH 公钥 (与当前密钥绑定)", Auth: authKey,
+		Params: []apiParam{
+			reqStr("public_key", "authorized_keys 格式的公钥"),
+			optStr("name", "备注, 留空则使用公钥中的注释"),
+		}, Response: model.SSHKey{}},
+	{Method: "POST", Path: "/cli/get-ssh-keys", Summary: "全部 SSH 公钥", Auth: authKey, AlsoGET: true,
+		Response: []model.SSHKey{}},
+	{Method: "POST", Path: "/cli/delete-ssh-key", Summary: "删除 SSH 公钥", Auth: authKey,
+		Params: []apiParam{reqStr("fingerprint", "SSHKey.Fingerprint")}},
+	{Method: "POST", Path: "/cli/qr", Summary: "消息或分享链接的二维码 (png, svg 或 txt), 内容太长时返回 413", Auth: authKey, AlsoGET: true,
+		Params: []apiParam{
+			optStr("a_or_i", "别名或流水号"),
+			optStr("token", "Share.Token, 使用分享链接代替消息内容"),
+			optStr("format", "png (默认), svg 或 txt (Unicode 方块字符)"),
+			optStr("level", "纠错等级 L (默认), M, Q, H"),
+			optInt("scale", "每个模块的像素数 (默认 8)"),
+			optBool("invert", "txt 格式用于浅色背景的终端"),
+		}, Produces: "image/png"},
+
+	{Method: "GET", Path: "/v2/messages", Summary: "列出消息, 带参数 q 时查找消息", Auth: authBearer,
+		Params: []apiParam{
+			optStr("q", "关键词"), optStr("bucket", "temp 或 perm"),
+			optInt("index", "从该流水号开始"), optInt("limit", "条数"),
+		}, Response: []model.TxtMsg{}},
+	{Method: "POST", Path: "/v2/messages", Summary: "新增暂存消息", Auth: authBearer,
+		JSONBody: struct {
+			Msg    string `json:"msg"`
+			Device string `json:"device,omitempty"`
+		}{}, Status: http.StatusCreated, Response: model.TxtMsg{}},
+	{Method: "GET", Path: "/v2/messages/:id", Summary: "获取一条消息", Auth: authBearer,
+		Response: model.TxtMsg{}},
+	{Method: "PATCH", Path: "/v2/messages/:id", Summary: "修改消息 (只修改出现的字段, 修改 cat 后 ID 会改变)", Auth: authBearer,
+		JSONBody: struct {
+			Msg   string         `json:"msg,omitempty"`
+			Alias string         `json:"alias,omitempty"`
+			Cat   model.Category `json:"cat,omitempty"`
+		}{}, Response: model.TxtMsg{}},
+	{Method: "DELETE", Path: "/v2/messages/:id", Summary: "删除消息", Auth: authBearer,
+		Status: http.StatusNoContent},
+	{Method: "GET", Path: "/v2/aliases", Summary: "全部别名", Auth: authBearer,
+		Response: []model.Alias{}},
+	{Method: "GET", Path: "/v2/aliases/:name", Summary: "获取别名指向的消息", Auth: authBearer,
+		Response: model.TxtMsg{}},
+
+	{Method: "GET", Path: "/raw/:alias_or_index", Summary: "只返回消息内容 (纯文本)", Auth: authKey,
+		Params: []apiParam{optStr("download", "作为文件下载，可指定文件名")}, Produces: "text/plain"},
+	{Method: "GET", Path: "/feed", Summary: "Atom feed, 支持 ETag 与 If-Modified-Since", Auth: authKey,
+		Params: []apiParam{
+			optStr("bucket", "temp 或 perm"),
+			optStr("tag", "包含 #tag 的消息"),
+			optStr("alias", "别名以此开头的消息"),
+			optInt("limit", "条数"),
+		}, Produces: "application/atom+xml"},
+
+	{Method: "GET", Path: "/ready", Summary: "服务器是否就绪, 关闭过程中返回 503", Response: Text{}},
+
+	{Method: "POST", Path: "/hooks/:adapter", Summary: "聊天工具的命令 (slack, mattermost, json), 例如 send foo, get email",
+		Produces: "application/json"},
+
+	{Method: "GET", Path: "/s/:token", Summary: "查看分享的消息 (网页)", Produces: "text/html"},
+	{Method: "POST", Path: "/s/:token", Summary: "输入密码后查看分享的消息 (网页)",
+		Params: []apiParam{optStr("password", "分享链接的密码")}, Produces: "text/html"},
+	{Method: "GET", Path: "/s/:token/raw", Summary: "只返回分享的消息内容 (纯文本)",
+		Params: []apiParam{optStr("password", "分享链接的密码 (也可使用 Basic auth)")}, Produces: "text/plain"},
+}
+
+// allAPIRoutes 返回 apiRoutes, 并为 AlsoGET 的路由添加 GET 版本。
+func allAPIRoutes() (routes []apiRoute) {
+	for _, route := range apiRoutes {
+		routes = append(routes, route)
+		if route.AlsoGET {
+			route.Method = "GET"
+			routes = append(routes, route)
+		}
+	}
+	return
+}
+
+// undocumented 判断是否不需要写进文档的路由 (静态文件等)。
+func undocumented(path string) bool {
+	return path == "/" || path == "/robots.txt" || path == "/favicon.ico" ||
+		strings.HasPrefix(path, "/public/") || strings.HasPrefix(path, "/js/")
+}
+
+// openAPISpec 根据 apiRoutes 生成 OpenAPI 3.0 文档。
+func openAPISpec() ([]byte, error) {
+	schemas := map[string]interface{}{}
+	errorRef := func(route apiRoute) interface{} {
+		if route.Auth == authBearer {
+			return schemaOf(reflect.TypeOf(APIError{}), schemas)
+		}
+		return schemaOf(reflect.TypeOf(Text{}), schemas)
+	}
+	// 在文档中出现但不是任何路由的返回内容
+	schemaOf(reflect.TypeOf(WsMessage{}), schemas)
+	schemaOf(reflect.TypeOf(WebhookPayload{}), schemas)
+
+	paths := map[string]map[string]interface{}{}
+	for _, route := range allAPIRoutes() {
+		op := map[string]interface{}{"summary": route.Summary}
+		params, fields := route.Params, []apiParam{}
+		switch route.Auth {
+		case authPassword:
+			params = append([]apiParam{reqStr("password", "主密码")}, params...)
+		case authKey:
+			op["security"] = keySecurity
+			params = append([]apiParam{optStr("password", "密钥 (不推荐，请尽量使用 security 中的方式)")}, params...)
+		case authSession:
+			op["security"] = []interface{}{map[string]interface{}{"session": []string{}}}
+		case authBearer:
+			op["security"] = keySecurity
+		}
+
+		var parameters []interface{}
+		for _, name := range pathParams(route.Path) {
+			parameters = append(parameters, map[string]interface{}{
+				"name": name, "in": "path", "required": true,
+				"schema": map[string]interface{}{"type": "string"},
+			})
+		}
+		if route.Method == "GET" {
+			for _, p := range params {
+				parameters = append(parameters, map[string]interface{}{
+					"name": p.Name, "in": "query", "required": p.Required,
+					"description": p.Desc, "schema": paramSchema(p),
+				})
+			}
+		} else {
+			fields = params
+		}
+		if len(parameters) > 0 {
+			op["parameters"] = parameters
+		}
+
+		if len(fields) > 0 {
+			props := map[string]interface{}{}
+			var required []string
+			for _, p := range fields {
+				schema := paramSchema(p)
+				schema["description"] = p.Desc
+				props[p.Name] = schema
+				if p.Required {
+					required = append(required, p.Name)
+				}
+			}
+			body := map[string]interface{}{"type": "object", "properties": props}
+			if len(required) > 0 {
+				body["required"] = required
+			}
+			op["requestBody"] = map[string]interface{}{
+				"required": true,
+				"content": map[string]interface{}{
+					"application/x-www-form-urlencoded": map[string]interface{}{"schema": body},
+				},
+			}
+		}
+		if route.JSONBody != nil {
+			op["requestBody"] = map[string]interface{}{
+				"required": true,
+				"content": map[string]interface{}{
+					"application/json": map[string]interface{}{
+						"schema": schemaOf(reflect.TypeOf(route.JSONBody), schemas),
+					},
+				},
+			}
+		}
+
+		status := route.Status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		success := map[string]interface{}{"description": http.StatusText(status)}
+		switch {
+		case route.Produces != "":
+			success["content"] = map[string]interface{}{
+				route.Produces: map[string]interface{}{"schema": map[string]interface{}{"type": "string"}},
+			}
+		case route.Response != nil:
+			success["content"] = map[string]interface{}{
+				"application/json": map[string]interface{}{
+					"schema": schemaOf(reflect.TypeOf(route.Response), schemas),
+				},
+			}
+		}
+		op["responses"] = map[string]interface{}{
+			fmt.Sprint(status): success,
+			"default": map[string]interface{}{
+				"description": "error",
+				"content": map[string]interface{}{
+					"application/json": map[string]interface{}{"schema": errorRef(route)},
+				},
+			},
+		}
+
+		path := openAPIPath(route.Path)
+		if paths[path] == nil {
+			paths[path] = map[string]interface{}{}
+		}
+		paths[path][strings.ToLower(route.Method)] = op
+	}
+
+	spec := map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":       "txt",
+			"description": "txt 是一个“云剪贴板”。/api 需要先登录; /cli, /v2, /raw 需要密钥, 可使用 Authorization: Bearer <key>, HTTP Basic auth (密码是密钥) 或 X-Txt-Key.",
+			"version":     "2",
+		},
+		"paths": paths,
+		"components": map[string]interface{}{
+			"schemas": schemas,
+			"securitySchemes": map[string]interface{}{
+				"session": map[string]interface{}{"type": "apiKey", "in": "cookie", "name": sessionName},
+				"bearer":  map[string]interface{}{"type": "http", "scheme": "bearer"},
+				"basic":   map[string]interface{}{"type": "http", "scheme": "basic", "description": "密码是密钥，用户名任意"},
+				"txtKey":  map[string]interface{}{"type": "apiKey", "in": "header", "name": "X-Txt-Key"},
+			},
+		},
+	}
+	return json.MarshalIndent(spec, "", "  ")
+}
+
+// 使用密钥的路由可以采用其中任意一种方式 (见 keyFromRequest)。
+var keySecurity = []interface{}{
+	map[string]interface{}{"bearer": []string{}},
+	map[string]interface{}{"basic": []string{}},
+	map[string]interface{}{"txtKey": []string{}},
+}
+
+// openAPIPath 把 /v2/messages/:id 转换为 /v2/messages/{id}
+func openAPIPath(path string) string {
+	parts := strings.Split(path, "/")
+	for i, part := range parts {
+		if strings.HasPrefix(part, ":") || strings.HasPrefix(part, "*") {
+			parts[i] = "{" + part[1:] + "}"
+		}
+	}
+	return strings.Join(parts, "/")
+}
+
+// pathParams 返回 gin 路径中的参数名，例如 /s/:token/raw 返回 [token].
+func pathParams(path string) (names []string) {
+	for _, part := range strings.Split(path, "/") {
+		if strings.HasPrefix(part, ":") || strings.HasPrefix(part, "*") {
+			names = append(names, part[1:])
+		}
+	}
+	return
+}
+
+func paramSchema(p apiParam) map[string]interface{} {
+	if p.Type == "array" {
+		return map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "string"}}
+	}
+	return map[string]interface{}{"type": p.Type}
+}
+
+// schemaOf 根据 Go 的类型生成 JSON Schema, 有名字的 struct 会被放进 schemas 并返回 $ref.
+func schemaOf(t reflect.Type, schemas map[string]interface{}) map[string]interface{} {
+	switch t.Kind() {
+	case reflect.Ptr:
+		return schemaOf(t.Elem(), schemas)
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{"type": "array", "items": schemaOf(t.Elem(), schemas)}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object"}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return structSchema(t, schemas)
+		}
+		if _, ok := schemas[t.Name()]; !ok {
+			schemas[t.Name()] = nil // 先占位，以免递归
+			schemas[t.Name()] = structSchema(t, schemas)
+		}
+		return map[string]interface{}{"$ref": "#/components/schemas/" + t.Name()}
+	}
+	return map[string]interface{}{}
+}
+
+func structSchema(t reflect.Type, schemas map[string]interface{}) map[string]interface{} {
+	props := map[string]interface{}{}
+	addStructFields(t, props, schemas)
+	return map[string]interface{}{"type": "object", "properties": props}
+}
+
+// addStructFields 与 encoding/json 一样展开嵌入的 struct.
+func addStructFields(t reflect.Type, props, schemas map[string]interface{}) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" && !field.Anonymous {
+			continue // 未导出
+		}
+		name := field.Name
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		if tagName := strings.Split(tag, ",")[0]; tagName != "" {
+			name = tagName
+		} else if field.Anonymous && field.Type.Kind() == reflect.Struct {
+			addStructFields(field.Type, props, schemas)
+			continue
+		}
+		props[name] = schemaOf(field.Type, schemas)
+	}
+}
+
+// openAPIHandler 返回 OpenAPI 文档。
+func openAPIHandler(spec []byte) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Data(OK, "application/json; charset=utf-8", spec)
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// routeKey 把路径参数替换为 {}, 以便参数名不同时报告为参数不一致，而不是缺少路由。
+func routeKey(method, path string) string {
+	parts := strings.Split(path, "/")
+	for i, part := range parts {
+		if strings.HasPrefix(part, ":") || strings.HasPrefix(part, "*") {
+			parts[i] = "{}"
+		}
+	}
+	return method + " " + strings.Join(parts, "/")
+}
+
+// TestAPIRoutes 检查 apiRoutes 与 main.go 里的路由是否一致 (不包括静态文件),
+// 包括路径参数的名称。
+func TestAPIRoutes(t *testing.T) {
+	newTestDB(t)
+	r, err := newRouter()
+	if err != nil {
+		t.Fatal(err)
+	}
+	documented := make(map[string]apiRoute)
+	for _, route := range allAPIRoutes() {
+		documented[routeKey(route.Method, route.Path)] = route
+	}
+	var missing []string
+	for _, route := range r.Routes() {
+		if undocumented(route.Path) {
+			continue
+		}
+		key := routeKey(route.Method, route.Path)
+		doc, ok := documented[key]
+		if !ok {
+			missing = append(missing, route.Method+" "+route.Path)
+			continue
+		}
+		delete(documented, key)
+		if doc.Path != route.Path {
+			t.Errorf("%s %s: path params do not match openapi.go (%s)", route.Method, route.Path, doc.Path)
+		}
+		for _, p := range doc.Params {
+			for _, name := range pathParams(route.Path) {
+				if p.Name == name {
+					t.Errorf("%s %s: %s is both a path param and a form/query param", route.Method, route.Path, name)
+				}
+			}
+		}
+	}
+	for _, route := range documented {
+		missing = append(missing, route.Method+" "+route.Path+" (no such route)")
+	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		t.Errorf("openapi.go and main.go do not match:\n  %s", strings.Join(missing, "\n  "))
+	}
+}
+
+// TestOpenAPIPathParams 检查生成的文档中每个路径参数都与 gin 的路径一致。
+func TestOpenAPIPathParams(t *testing.T) {
+	data, err := openAPISpec()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var spec struct {
+		Paths map[string]map[string]struct {
+			Parameters []struct {
+				Name string
+				In   string
+			}
+		}
+	}
+	if err := json.Unmarshal(data, &spec); err != nil {
+		t.Fatal(err)
+	}
+	for _, route := range allAPIRoutes() {
+		path := openAPIPath(route.Path)
+		op, ok := spec.Paths[path][strings.ToLower(route.Method)]
+		if !ok {
+			t.Errorf("%s %s is not in the spec", route.Method, path)
+			continue
+		}
+		var got []string
+		for _, p := range op.Parameters {
+			if p.In == "path" {
+				got = append(got, p.Name)
+				if !strings.Contains(path, "{"+p.Name+"}") {
+					t.Errorf("%s %s: path param %s is not in the path", route.Method, path, p.Name)
+				}
+			}
+		}
+		if want := pathParams(route.Path); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s %s: path params = %v; want %v", route.Method, path, got, want)
+		}
+	}
+}
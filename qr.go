@@ -0,0 +1,162 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/ahui2016/txt/mydb"
+	"github.com/ahui2016/txt/util"
+	"github.com/gin-gonic/gin"
+	"rsc.io/qr"
+)
+
+// 二维码四周的空白 (quiet zone) 宽度，单位是模块 (黑白方块)。
+const qrQuietZone = 4
+
+// qrLevels 是二维码的纠错等级，以及该等级下最多可容纳的字节数 (version 40, byte mode)。
+var qrLevels = map[string]struct {
+	level    qr.Level
+	capacity int
+}{
+	"L": {qr.L, 2953},
+	"M": {qr.M, 2331},
+	"Q": {qr.Q, 1663},
+	"H": {qr.H, 1273},
+}
+
+// qrEncode 生成二维码，内容太长时返回清楚的错误信息。
+func qrEncode(text, level string) (*qr.Code, error) {
+	lv, ok := qrLevels[strings.ToUpper(level)]
+	if !ok {
+		return nil, fmt.Errorf("unknown level: %s (use L, M, Q or H)", level)
+	}
+	code, err := qr.Encode(text, lv.level)
+	if err != nil {
+		return nil, fmt.Errorf("the content is too long for a QR code: %d bytes, the limit is %d bytes at level %s",
+			len(text), lv.capacity, strings.ToUpper(level))
+	}
+	return code, nil
+}
+
+// qrSVG 把二维码转换为 SVG, 每个模块的大小是 1 (由 viewBox 缩放)。
+func qrSVG(code *qr.Code, scale int) []byte {
+	size := code.Size + qrQuietZone*2
+	var b strings.Builder
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 %d %d" width="%d" height="%d" shape-rendering="crispEdges">`,
+		size, size, size*scale, size*scale)
+	fmt.Fprintf(&b, `<rect width="%d" height="%d" fill="#fff"/><path fill="#000" d="`, size, size)
+	for y := 0; y < code.Size; y++ {
+		for x := 0; x < code.Size; x++ {
+			if code.Black(x, y) {
+				fmt.Fprintf(&b, "M%d %dh1v1h-1z", x+qrQuietZone, y+qrQuietZone)
+			}
+		}
+	}
+	b.WriteString(`"/></svg>`)
+	return []byte(b.String())
+}
+
+// qrText 使用 Unicode 方块字符把二维码画在终端里，每个字符表示上下两个模块。
+// 默认把白色模块画成方块，适合深色背景的终端; invert 为 true 时适合浅色背景。
+func qrText(code *qr.Code, invert bool) string {
+	// 二维码以外的区域 (quiet zone) 是白色
+	white := func(x, y int) bool {
+		return !code.Black(x, y) != invert
+	}
+	var b strings.Builder
+	// 终端里的空白只保留一半，以免占用太多行。
+	from, to := -qrQuietZone/2, code.Size+qrQuietZone/2
+	for y := from; y < to; y += 2 {
+		for x := from; x < to; x++ {
+			top, bottom := white(x, y), white(x, y+1)
+			if y+1 >= to {
+				bottom = false
+			}
+			switch {
+			case top && bottom:
+				b.WriteString("█")
+			case top:
+				b.WriteString("▀")
+			case bottom:
+				b.WriteString("▄")
+			default:
+				b.WriteString(" ")
+			}
+		}
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+// qrHandler 把一条消息的内容 (或者参数 token 所指定的分享链接) 转换为二维码,
+// format 可以是 png (默认), svg 或 txt.
+func qrHandler(c *gin.Context) {
+	type form struct {
+		A_or_I string `form:"a_or_i"`
+		Token  string `form:"token"`
+		Format string `form:"format"`
+		Level  string `form:"level"`
+		Scale  int    `form:"scale" binding:"min=0,max=32"`
+		Invert bool   `form:"invert"`
+	}
+	var f form
+	if BindCheck(c, &f) {
+		return
+	}
+	if f.Level == "" {
+		f.Level = "L"
+	}
+	if _, ok := qrLevels[strings.ToUpper(f.Level)]; !ok {
+		c.JSON(http.StatusBadRequest, Text{"unknown level: " + f.Level})
+		return
+	}
+	if f.Scale == 0 {
+		f.Scale = 8
+	}
+
+	var text string
+	switch {
+	case f.Token != "":
+		share, err := db.GetShare(f.Token)
+		if errors.Is(err, mydb.ErrNoResult) {
+			c.JSON(http.StatusNotFound, Text{"the share link does not exist"})
+			return
+		}
+		if checkErr(c, err) {
+			return
+		}
+		if share.Expired(util.TimeNow()) {
+			c.JSON(http.StatusGone, Text{mydb.ErrShareExpired.Error()})
+			return
+		}
+		text = newShareLink(c, share).Link
+	case f.A_or_I != "":
+		tm, err := db.GetByAliasIndex(f.A_or_I)
+		if checkErr(c, err) {
+			return
+		}
+		text = tm.Msg
+	default:
+		c.JSON(http.StatusBadRequest, Text{"a_or_i or token is required"})
+		return
+	}
+
+	code, err := qrEncode(text, f.Level)
+	if err != nil {
+		c.JSON(http.StatusRequestEntityTooLarge, Text{err.Error()})
+		return
+	}
+	switch strings.ToLower(f.Format) {
+	case "", "png":
+		code.Scale = f.Scale
+		c.Data(OK, "image/png", code.PNG())
+	case "svg":
+		c.Data(OK, "image/svg+xml", qrSVG(code, f.Scale))
+	case "txt", "text":
+		c.Data(OK, "text/plain; charset=utf-8", []byte(qrText(code, f.Invert)))
+	default:
+		c.JSON(http.StatusBadRequest, Text{"unknown format: " + f.Format})
+	}
+}
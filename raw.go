@@ -0,0 +1,34 @@
+package main
+
+import (
+	"errors"
+	"mime"
+	"net/http"
+
+	"github.com/ahui2016/txt/mydb"
+	"github.com/gin-gonic/gin"
+)
+
+// rawHandler 只返回消息内容 (纯文本), 以便直接用于管道，例如
+// curl -H "Authorization: Bearer $KEY" https://example.com/raw/deploy | bash
+// 带参数 download 时作为文件下载, download 的值是文件名 (留空或为 1 时使用别名或流水号)。
+func rawHandler(c *gin.Context) {
+	a_or_i := c.Param("alias_or_index")
+	tm, err := db.GetByAliasIndex(a_or_i)
+	if errors.Is(err, mydb.ErrNoResult) {
+		c.String(http.StatusNotFound, "not found: %s\n", a_or_i)
+		return
+	}
+	if err != nil {
+		c.String(http.StatusInternalServerError, "%s\n", err)
+		return
+	}
+	if filename, ok := c.GetQuery("download"); ok {
+		if filename == "" || filename == "1" || filename == "true" {
+			filename = a_or_i + ".txt"
+		}
+		c.Header("Content-Disposition",
+			mime.FormatMediaType("attachment", map[string]string{"filename": filename}))
+	}
+	c.Data(OK, "text/plain; charset=utf-8", []byte(tm.Msg))
+}
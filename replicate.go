@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ahui2016/txt/model"
+	"github.com/gin-gonic/gin"
+)
+
+// 每次最多获取多少条修改记录
+const replicateLimit = 100
+
+// ChangeList 是 "/cli/replicate" 的返回结果。
+type ChangeList struct {
+	NodeID  string
+	Changes []model.Change
+	Last    uint64 // 本次返回的最后一条修改记录的流水号
+}
+
+func cliReplicateHandler(c *gin.Context) {
+	type form struct {
+		Since uint64 `form:"since"`
+		Limit int    `form:"limit"`
+	}
+	var f form
+	if BindCheck(c, &f) {
+		return
+	}
+	if f.Limit <= 0 || f.Limit > replicateLimit {
+		f.Limit = replicateLimit
+	}
+	// 新的 peer (since 为零) 先获取全部消息，不受 limit 限制。
+	if f.Since == 0 {
+		changes, last, err := db.Snapshot()
+		if checkErr(c, err) {
+			return
+		}
+		c.JSON(OK, ChangeList{
+			NodeID:  db.GetConfig().NodeID,
+			Changes: changes,
+			Last:    last,
+		})
+		return
+	}
+	changes, err := db.ChangesSince(f.Since, f.Limit)
+	if checkChangesErr(c, err) {
+		return
+	}
+	last := f.Since
+	if n := len(changes); n > 0 {
+		last = changes[n-1].Seq
+	}
+	c.JSON(OK, ChangeList{
+		NodeID:  db.GetConfig().NodeID,
+		Changes: changes,
+		Last:    last,
+	})
+}
+
+// pullChanges 从 peer 获取上次之后的全部修改记录并应用到本地数据库。
+func pullChanges(ctx context.Context, peer, peerKey string) (applied int, err error) {
+	for {
+		since, err := db.GetPeerCursor(peer)
+		if err != nil {
+			return applied, err
+		}
+		list, err := fetchChanges(ctx, peer, peerKey, since)
+		if err != nil {
+			return applied, err
+		}
+		if list.NodeID == db.GetConfig().NodeID {
+			return applied, fmt.Errorf("the peer is this server itself")
+		}
+		n, err := db.ApplyChanges(list.Changes)
+		applied += n
+		if err != nil {
+			return applied, err
+		}
+		if err := db.SetPeerCursor(peer, list.Last); err != nil {
+			return applied, err
+		}
+		if len(list.Changes) < replicateLimit {
+			return applied, nil
+		}
+	}
+}
+
+func fetchChanges(ctx context.Context, peer, peerKey string, since uint64) (list ChangeList, err error) {
+	form := url.Values{}
+	form.Set("since", strconv.FormatUint(since, 10))
+	form.Set("limit", strconv.Itoa(replicateLimit))
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost,
+		strings.TrimSuffix(peer, "/")+"/cli/replicate", strings.NewReader(form.Encode()))
+	if err != nil {
+		return
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Authorization", "Bearer "+peerKey)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != OK {
+		var text Text
+		_ = json.NewDecoder(resp.Body).Decode(&text)
+		if resp.StatusCode == http.StatusGone {
+			// 本地落后太多，peer 已删除所需的修改记录。
+			return list, fmt.Errorf("%s: %s (restore from a backup of the peer to catch up)", resp.Status, text.Message)
+		}
+		return list, fmt.Errorf("%s: %s", resp.Status, text.Message)
+	}
+	err = json.NewDecoder(resp.Body).Decode(&list)
+	return
+}
+
+// replicateLoop 每隔 peerInterval 从 peer 同步一次。
+// 两台服务器互相把对方设为 peer 即可实现双向同步。
+func replicateLoop(ctx context.Context) {
+	if *peer == "" {
+		return
+	}
+	log.Print("[Replicate] peer: ", *peer)
+	ticker := time.NewTicker(*peerInterval)
+	defer ticker.Stop()
+	for {
+		n, err := pullChanges(ctx, *peer, *peerKey)
+		if err != nil {
+			log.Print("[Replicate] ", err)
+		} else if n > 0 {
+			log.Printf("[Replicate] %d changes applied", n)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// trimChangesLoop 每隔一小时删除旧的修改记录，只保留最新的 changelogKeep 条。
+func trimChangesLoop(ctx context.Context) {
+	if *changelogKeep == 0 {
+		return
+	}
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+	for {
+		n, err := db.TrimChanges(*changelogKeep)
+		if err != nil {
+			log.Print("[Changelog] ", err)
+		} else if n > 0 {
+			log.Printf("[Changelog] %d old changes trimmed", n)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/ahui2016/txt/mydb"
+)
+
+func TestFetchChanges(t *testing.T) {
+	srv, key := newTestServer(t)
+	insertTestMsgs(t, 3)
+	ctx := context.Background()
+
+	list, err := fetchChanges(ctx, srv.URL, key, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Changes) != 3 || list.Last != 3 || list.NodeID != db.GetConfig().NodeID {
+		t.Errorf("got %d changes, Last %d, NodeID %q", len(list.Changes), list.Last, list.NodeID)
+	}
+	if _, err := fetchChanges(ctx, srv.URL, "wrong-key", 0); err == nil || !strings.HasPrefix(err.Error(), "401") {
+		t.Errorf("fetchChanges with a wrong key: %v; want 401", err)
+	}
+
+	// peer 已删除所需的修改记录
+	if _, err := db.TrimChanges(1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fetchChanges(ctx, srv.URL, key, 1); err == nil || !strings.HasPrefix(err.Error(), "410") {
+		t.Errorf("fetchChanges after trimming: %v; want 410", err)
+	}
+	if status, code := v2Do(t, "GET", srv.URL+"/cli/changes?since=1", key, ""); status != 410 || code != codeTrimmed {
+		t.Errorf("/cli/changes after trimming: %d %s; want 410 %s", status, code, codeTrimmed)
+	}
+	if status, _ := v2Do(t, "GET", srv.URL+"/cli/changes?since=2", key, ""); status != OK {
+		t.Errorf("/cli/changes since the first retained change: %d; want 200", status)
+	}
+}
+
+// 新的 peer 从已有消息 (且旧的修改记录已被删除) 的服务器获取全部消息。
+func TestPullIntoEmptyPeer(t *testing.T) {
+	srv, key := newTestServer(t)
+	insertTestMsgs(t, 5)
+	if _, err := db.TrimChanges(2); err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	list, err := fetchChanges(ctx, srv.URL, key, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Changes) != 5 || list.Last != 5 {
+		t.Fatalf("got %d changes, Last %d; want 5, 5", len(list.Changes), list.Last)
+	}
+	peer := mydb.NewMemDB()
+	if n, err := peer.ApplyChanges(list.Changes); n != 5 || err != nil {
+		t.Fatalf("applied %d, %v; want 5", n, err)
+	}
+	want, _ := db.GetRecentItems(10)
+	got, _ := peer.GetRecentItems(10)
+	if len(got) != len(want) {
+		t.Fatalf("peer has %d messages; want %d", len(got), len(want))
+	}
+	for i := range want {
+		if got[i].ID != want[i].ID || got[i].Msg != want[i].Msg {
+			t.Errorf("message %d: got %s %q; want %s %q", i, got[i].ID, got[i].Msg, want[i].ID, want[i].Msg)
+		}
+	}
+
+	// 之后从 Last 开始获取新的修改记录
+	tm, err := db.NewTxtMsg("new")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.InsertTxtMsg(tm); err != nil {
+		t.Fatal(err)
+	}
+	list, err = fetchChanges(ctx, srv.URL, key, list.Last)
+	if err != nil || len(list.Changes) != 1 || list.Last != 6 {
+		t.Errorf("got %d changes, Last %d, %v; want 1 change, Last 6", len(list.Changes), list.Last, err)
+	}
+}
@@ -2,8 +2,11 @@ package main
 
 import (
 	"crypto/rand"
+	"errors"
 	"fmt"
 	"net/http"
+	"strings"
+	"sync"
 
 	"github.com/ahui2016/txt/util"
 	"github.com/gin-contrib/sessions"
@@ -11,75 +14,138 @@ import (
 )
 
 const (
-	sessionName    = "txt-session"
-	cookieSignIn   = "txt-cookie-signin"
-	passwordMaxTry = 5
-	allIP_MaxTry   = 100
-	day            = 24 * 60 * 60
-	defaultMaxAge  = 30 * day
+	sessionName  = "txt-session"
+	cookieSignIn = "txt-cookie-signin"
+	day          = 24 * 60 * 60
 )
 
-var ipTryCount = make(map[string]int)
+// tryCounter 记录每个 IP 输错密码 (或密钥) 的次数。
+// 多个请求会同时读写，因此需要加锁。
+type tryCounter struct {
+	sync.Mutex
+	count  map[string]int
+	global bool // 是否同时限制全部 IP 的错误次数 (键名 "all")
+}
+
+func newTryCounter(global bool) *tryCounter {
+	return &tryCounter{count: make(map[string]int), global: global}
+}
 
-func checkIPTryCount(ip string) error {
+// check 在该 IP (或全部 IP) 输错的次数达到上限时返回错误。
+func (tc *tryCounter) check(ip string) error {
 	if *demo {
 		return nil // 演示版允许无限重试密码
 	}
-	if ipTryCount[ip] >= passwordMaxTry || ipTryCount["all"] >= allIP_MaxTry {
+	tc.Lock()
+	defer tc.Unlock()
+	if tc.count[ip] >= *passwordMaxTry || (tc.global && tc.count["all"] >= *allIP_MaxTry) {
 		return fmt.Errorf("no more try, input wrong password too many times")
 	}
 	return nil
 }
 
+// tried 记录一次检查的结果，成功时清空该 IP 的错误次数。
+func (tc *tryCounter) tried(ip string, ok bool) {
+	tc.Lock()
+	defer tc.Unlock()
+	if ok {
+		delete(tc.count, ip)
+		return
+	}
+	tc.count[ip]++
+	if tc.global {
+		tc.count["all"]++
+	}
+}
+
+func (tc *tryCounter) get(key string) int {
+	tc.Lock()
+	defer tc.Unlock()
+	return tc.count[key]
+}
+
+func (tc *tryCounter) reset() {
+	tc.Lock()
+	defer tc.Unlock()
+	tc.count = make(map[string]int)
+}
+
+// ipTryCount 记录输错主密码与密钥的次数。
+var ipTryCount = newTryCounter(true)
+
+// errKeyRequired 表示请求中没有密钥。这种请求不计入密码错误次数，
+// 以免忘记带密钥的脚本 (或探测网址的爬虫) 导致真正的用户被封锁。
+var errKeyRequired = errors.New("key required")
+
 // checkPwdAndIP 检查 IP 与主密码，返回 true 表示有错误。
 func checkPwdAndIP(c *gin.Context, pwd string) (exit bool) {
 	ip := c.ClientIP()
-	if err := checkIPTryCount(ip); err != nil {
+	if err := ipTryCount.check(ip); err != nil {
 		c.JSON(http.StatusForbidden, Text{err.Error()})
 		return true
 	}
-	if pwd != db.Config.Password {
-		ipTryCount[ip]++
-		ipTryCount["all"]++
+	if pwd != db.GetConfig().Password {
+		ipTryCount.tried(ip, false)
 		c.JSON(http.StatusUnauthorized, Text{"wrong password"})
 		return true
 	}
-	ipTryCount[ip] = 0
+	ipTryCount.tried(ip, true)
 	return false
 }
 
-// checkKeyAndIP 检查 IP 与日常操作密钥，返回 true 表示有错误。
-func checkKeyAndIP(c *gin.Context, secretKey string) (exit bool) {
+// checkKeyAndIP 检查 IP 与日常操作密钥，出错时使用 abort 返回错误，并返回 true.
+func checkKeyAndIP(c *gin.Context, secretKey string, abort abortFunc) (exit bool) {
+	if secretKey == "" {
+		abort(c, http.StatusUnauthorized, codeKeyRequired, errKeyRequired.Error())
+		return true
+	}
 	ip := c.ClientIP()
-	if err := checkIPTryCount(ip); err != nil {
-		c.JSON(http.StatusForbidden, Text{err.Error()})
+	if err := ipTryCount.check(ip); err != nil {
+		abort(c, http.StatusForbidden, codeTooManyTries, err.Error())
 		return true
 	}
 	if err := db.CheckKey(secretKey); err != nil {
-		ipTryCount[ip]++
-		ipTryCount["all"]++
-		c.JSON(http.StatusUnauthorized, Text{err.Error()})
+		ipTryCount.tried(ip, false)
+		_, code := errorCode(err)
+		abort(c, http.StatusUnauthorized, code, err.Error())
 		return true
 	}
-	ipTryCount[ip] = 0
+	ipTryCount.tried(ip, true)
 	return false
 }
 
+// CliCheckKey 检查密钥，密钥的传递方式见 keyFromRequest.
+// 错误信息同时包含 message 与 code, 因此旧的客户端也能读取。
 func CliCheckKey() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		var form SignInForm
-		if BindCheck(c, &form) {
-			c.Abort()
-			return
-		}
-		if checkKeyAndIP(c, form.Password) {
-			c.Abort()
+		if checkKeyAndIP(c, keyFromRequest(c), v2Abort) {
 			return
 		}
 		c.Next()
 	}
 }
 
+// keyFromRequest 依次尝试从以下位置获取密钥：
+// "Authorization: Bearer <key>", HTTP Basic auth 的密码 (用户名任意),
+// "X-Txt-Key: <key>", 表单或查询参数 password.
+// 推荐使用前三种，以免密钥出现在请求内容或日志中。
+func keyFromRequest(c *gin.Context) string {
+	auth := c.GetHeader("Authorization")
+	if key := strings.TrimPrefix(auth, "Bearer "); key != auth {
+		return strings.TrimSpace(key)
+	}
+	if _, pwd, ok := c.Request.BasicAuth(); ok {
+		return pwd
+	}
+	if key := c.GetHeader("X-Txt-Key"); key != "" {
+		return key
+	}
+	if key, ok := c.GetPostForm("password"); ok {
+		return key
+	}
+	return c.Query("password")
+}
+
 func isSignedIn(c *gin.Context) bool {
 	session := sessions.Default(c)
 	yes, _ := session.Get(cookieSignIn).(bool)
@@ -96,6 +162,34 @@ func CheckSignIn() gin.HandlerFunc {
 	}
 }
 
+// abortFunc 返回错误并终止请求，使同一个 middleware 可以用于 /v2 (v2Abort) 与其他接口 (abortText)。
+type abortFunc func(c *gin.Context, status int, code, message string)
+
+// abortText 使用旧的错误格式 {"message": ...}, 忽略 code.
+func abortText(c *gin.Context, status int, _, message string) {
+	c.AbortWithStatusJSON(status, Text{message})
+}
+
+// CheckWritable 在只读模式中拒绝一切修改数据的请求。
+func CheckWritable() gin.HandlerFunc {
+	return checkWritable(abortText)
+}
+
+// V2CheckWritable 与 CheckWritable 相同，但使用 v2 的错误格式。
+func V2CheckWritable() gin.HandlerFunc {
+	return checkWritable(v2Abort)
+}
+
+func checkWritable(abort abortFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if *readonly {
+			abort(c, http.StatusForbidden, codeReadOnly, "Read-only Mode (只读模式) 不可修改数据。")
+			return
+		}
+		c.Next()
+	}
+}
+
 func generateRandomKey() []byte {
 	b := make([]byte, 32)
 	_, err := rand.Read(b)
@@ -104,7 +198,7 @@ func generateRandomKey() []byte {
 }
 
 func newNormalOptions() sessions.Options {
-	return newOptions(defaultMaxAge)
+	return newOptions(int(sessionMaxAge.Seconds()))
 }
 
 func newExpireOptions() sessions.Options {
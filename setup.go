@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/ahui2016/txt/mydb"
+	"github.com/ahui2016/txt/util"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	minPasswordLength = 8
+	genPasswordSize   = 18 // 随机字节数，转换后是 24 个字符
+)
+
+// errSetupRequired 是尚未设置主密码时返回的错误信息。
+var errSetupRequired = errors.New(`setup required: stop the server and run "txt init"`)
+
+// needSetup 在数据库尚未设置主密码时返回 true.
+func needSetup() bool {
+	return db.GetConfig().Password == ""
+}
+
+// CheckSetup 在设置主密码 (txt init) 之前拒绝一切请求。
+func CheckSetup() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if needSetup() {
+			c.AbortWithStatusJSON(http.StatusServiceUnavailable, Text{errSetupRequired.Error()})
+			return
+		}
+		c.Next()
+	}
+}
+
+// genPassword 生成一个随机主密码 (URL-safe, 方便在 shell 里使用)。
+func genPassword() string {
+	return base64.RawURLEncoding.EncodeToString(util.RandomBytes(genPasswordSize))
+}
+
+// initPassword 在启动服务器时检查主密码。演示版使用 mydb.DefaultPassword,
+// 纯内存数据库无法使用 txt init, 因此自动生成主密码并打印出来。
+func initPassword() {
+	switch {
+	case *demo:
+		util.Panic(db.InitPassword(mydb.DefaultPassword))
+	case *memory:
+		pwd := genPassword()
+		util.Panic(db.InitPassword(pwd))
+		fmt.Println("[Password]", pwd)
+		fmt.Println("[Key]", db.GetConfig().Key)
+	case needSetup():
+		log.Print("[Setup] ", errSetupRequired)
+	case db.GetConfig().Password == mydb.DefaultPassword:
+		log.Print(`[Warning] the password is still the old default "abc", please change it or run "txt init"`)
+	}
+}
+
+// cliInit 设置初始主密码 (省略 -password 时自动生成), 并打印主密码与密钥。
+// 需要直接打开数据库，因此必须先停止服务器。
+func cliInit(args []string) error {
+	password := flag.String("password", "", "the initial password (generated if omitted)")
+	if err := flag.CommandLine.Parse(args); err != nil {
+		return err
+	}
+	if _, err := applyConfig(flag.CommandLine); err != nil {
+		return err
+	}
+	if *demo || *memory || *readonly {
+		return fmt.Errorf("txt init cannot be used with -demo, -memory or -readonly")
+	}
+	pwd := *password
+	if pwd == "" {
+		pwd = genPassword()
+	} else if len(pwd) < minPasswordLength {
+		return fmt.Errorf("the password must be at least %d characters", minPasswordLength)
+	}
+
+	dbPath := getDBPath()
+	boltDB := new(mydb.DB)
+	if err := boltDB.Open(dbPath); err != nil {
+		return fmt.Errorf("cannot open %s (is the server running?): %w", dbPath, err)
+	}
+	defer boltDB.Close()
+	if err := boltDB.InitPassword(pwd); err != nil {
+		if errors.Is(err, mydb.ErrPasswordSet) {
+			return fmt.Errorf("%w, change it on the web page instead", err)
+		}
+		return err
+	}
+	fmt.Println("Database:", dbPath)
+	if *password == "" {
+		fmt.Println("Password:", pwd)
+	}
+	fmt.Println("Key:     ", boltDB.GetConfig().Key)
+	fmt.Println("They are shown only once, please keep them in a safe place.")
+	return nil
+}
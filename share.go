@@ -0,0 +1,218 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/ahui2016/txt/model"
+	"github.com/ahui2016/txt/mydb"
+	"github.com/ahui2016/txt/util"
+	"github.com/gin-gonic/gin"
+)
+
+// ShareLink 是返回给客户端的分享链接。
+type ShareLink struct {
+	model.Share
+	Link string // 完整的网址
+}
+
+// baseURL 返回本服务器的网址 (不含末尾的 "/"), 例如 "https://example.com".
+func baseURL(c *gin.Context) string {
+	scheme := "http"
+	if c.Request.TLS != nil || c.GetHeader("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	return scheme + "://" + c.Request.Host
+}
+
+func newShareLink(c *gin.Context, share model.Share) ShareLink {
+	return ShareLink{Share: share, Link: baseURL(c) + "/s/" + share.Token}
+}
+
+// createShareHandler 为一条消息生成分享链接。
+// expires 是有效期 (例如 30m, 24h), 留空表示永不过期; max_views 为零表示不限次数。
+func createShareHandler(c *gin.Context) {
+	type form struct {
+		A_or_I   string `form:"a_or_i" binding:"required"`
+		Expires  string `form:"expires"`
+		MaxViews int    `form:"max_views" binding:"min=0"`
+		Password string `form:"share_password"`
+	}
+	var f form
+	if BindCheck(c, &f) {
+		return
+	}
+	var expires int64
+	if f.Expires != "" {
+		d, err := time.ParseDuration(f.Expires)
+		if err != nil || d <= 0 {
+			c.JSON(http.StatusBadRequest, Text{"invalid expires: " + f.Expires})
+			return
+		}
+		expires = util.TimeNow() + int64(d.Seconds())
+	}
+	tm, err := db.GetByAliasIndex(f.A_or_I)
+	if checkErr(c, err) {
+		return
+	}
+	share, err := mydb.NewShare(tm.ID, expires, f.MaxViews, f.Password)
+	if checkErr(c, err) {
+		return
+	}
+	if checkErr(c, db.PutShare(share)) {
+		return
+	}
+	c.JSON(OK, newShareLink(c, share))
+}
+
+func getSharesHandler(c *gin.Context) {
+	shares, err := db.GetShares()
+	if checkErr(c, err) {
+		return
+	}
+	links := []ShareLink{}
+	for _, share := range shares {
+		links = append(links, newShareLink(c, share))
+	}
+	c.JSON(OK, links)
+}
+
+func revokeShareHandler(c *gin.Context) {
+	type form struct {
+		Token string `form:"token" binding:"required"`
+	}
+	var f form
+	if BindCheck(c, &f) {
+		return
+	}
+	checkErr(c, db.DeleteShare(f.Token))
+}
+
+// shareTries 记录输错分享密码的次数，键名是 "token ip" (每个 IP) 与 "token" (全部 IP)。
+// 与 ipTryCount 分开，以免有人故意输错分享密码导致主密码与密钥被封锁。
+var shareTries = struct {
+	sync.Mutex
+	count map[string]int
+}{count: make(map[string]int)}
+
+func checkShareTryCount(token, ip string) error {
+	if *demo {
+		return nil
+	}
+	shareTries.Lock()
+	defer shareTries.Unlock()
+	if shareTries.count[token+" "+ip] >= *passwordMaxTry || shareTries.count[token] >= *allIP_MaxTry {
+		return fmt.Errorf("no more try, input wrong password too many times")
+	}
+	return nil
+}
+
+// shareTried 记录一次密码检查的结果，成功时清空该 IP 的错误次数。
+func shareTried(token, ip string, ok bool) {
+	shareTries.Lock()
+	defer shareTries.Unlock()
+	if ok {
+		delete(shareTries.count, token+" "+ip)
+		return
+	}
+	shareTries.count[token+" "+ip]++
+	shareTries.count[token]++
+}
+
+// viewShare 检查分享链接与密码，成功时返回消息 (同时增加查看次数)。
+// 出错时返回相应的状态码, 如果需要密码则 needPwd 为 true.
+func viewShare(c *gin.Context, password string) (tm model.TxtMsg, status int, needPwd bool, err error) {
+	share, err := db.GetShare(c.Param("token"))
+	if errors.Is(err, mydb.ErrNoResult) {
+		return tm, http.StatusNotFound, false, errors.New("the share link does not exist")
+	}
+	if err != nil {
+		return tm, http.StatusInternalServerError, false, err
+	}
+	if share.Expired(util.TimeNow()) {
+		return tm, http.StatusGone, false, mydb.ErrShareExpired
+	}
+	if share.HasPassword {
+		ip := c.ClientIP()
+		if err := checkShareTryCount(share.Token, ip); err != nil {
+			return tm, http.StatusForbidden, false, err
+		}
+		if password == "" {
+			return tm, http.StatusUnauthorized, true, nil
+		}
+		err := mydb.CheckSharePassword(share, password)
+		shareTried(share.Token, ip, err == nil)
+		if err != nil {
+			return tm, http.StatusUnauthorized, true, err
+		}
+	}
+	tm, err = db.ViewShare(share.Token)
+	switch {
+	case errors.Is(err, mydb.ErrShareExpired):
+		return tm, http.StatusGone, false, err
+	case errors.Is(err, mydb.ErrNoResult):
+		return tm, http.StatusNotFound, false, errors.New("the share link does not exist")
+	case err != nil:
+		return tm, http.StatusInternalServerError, false, err
+	}
+	return tm, OK, false, nil
+}
+
+var sharePage = template.Must(template.New("share").Parse(`<!DOCTYPE html>
+<html lang="zh-CN">
+<head>
+  <meta charset="UTF-8">
+  <meta name="viewport" content="width=device-width, initial-scale=1, shrink-to-fit=no">
+  <meta name="robots" content="noindex">
+  <link rel="stylesheet" href="/public/simple.css">
+  <title>txt share</title>
+</head>
+<body>
+{{if .Error}}<p><mark>{{.Error}}</mark></p>{{end}}
+{{if .NeedPwd}}
+  <form method="post">
+    <label>Password (密码) <input type="password" name="password" autofocus></label>
+    <button type="submit">View</button>
+  </form>
+{{else if .Msg}}
+  <pre id="msg">{{.Msg}}</pre>
+  <button onclick="navigator.clipboard.writeText(document.getElementById('msg').textContent)">Copy (复制)</button>
+{{end}}
+</body>
+</html>
+`))
+
+// sharePageHandler 显示分享的消息 (GET), 需要密码时显示表单 (POST 提交密码)。
+func sharePageHandler(c *gin.Context) {
+	tm, status, needPwd, err := viewShare(c, c.PostForm("password"))
+	data := struct {
+		Msg     string
+		NeedPwd bool
+		Error   string
+	}{Msg: tm.Msg, NeedPwd: needPwd}
+	if err != nil {
+		data.Error = err.Error()
+	}
+	c.Header("Cache-Control", "no-store")
+	c.Status(status)
+	c.Header("Content-Type", "text/html; charset=utf-8")
+	_ = sharePage.Execute(c.Writer, data)
+}
+
+// shareRawHandler 只返回分享的消息内容，密码可通过 Basic auth 或查询参数 password 传递。
+func shareRawHandler(c *gin.Context) {
+	tm, status, needPwd, err := viewShare(c, keyFromRequest(c))
+	c.Header("Cache-Control", "no-store")
+	if needPwd && err == nil {
+		err = errors.New("password required")
+	}
+	if err != nil {
+		c.String(status, "%s\n", err)
+		return
+	}
+	c.Data(OK, "text/plain; charset=utf-8", []byte(tm.Msg))
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/ahui2016/txt/model"
+	"github.com/ahui2016/txt/mydb"
+)
+
+func TestSharePasswordTries(t *testing.T) {
+	srv, key := newTestServer(t)
+	id, err := model.DateIDAt(time.Now(), mydb.BeijingTime)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.InsertTxtMsg(model.TxtMsg{ID: id, Msg: "shared", Cat: model.CatTemp}); err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.PostForm(srv.URL+"/cli/share", url.Values{
+		"password": {key}, "a_or_i": {"t1"}, "share_password": {"1234"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var link ShareLink
+	err = json.NewDecoder(resp.Body).Decode(&link)
+	resp.Body.Close()
+	if err != nil || resp.StatusCode != OK {
+		t.Fatalf("create share: %d %v", resp.StatusCode, err)
+	}
+
+	view := func(password string) int {
+		t.Helper()
+		resp, err := http.PostForm(link.Link, url.Values{"password": {password}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	if status := view("1234"); status != OK {
+		t.Fatalf("right password: %d", status)
+	}
+	for i := 0; i < *passwordMaxTry; i++ {
+		if status := view("wrong"); status != http.StatusUnauthorized {
+			t.Fatalf("wrong password %d: %d; want 401", i, status)
+		}
+	}
+	if status := view("1234"); status != http.StatusForbidden {
+		t.Errorf("after too many wrong passwords: %d; want 403", status)
+	}
+
+	// 输错分享密码不影响主密码与密钥
+	if n := ipTryCount.get("127.0.0.1") + ipTryCount.get("all"); n > 0 {
+		t.Errorf("got %d tries of the main password; want none", n)
+	}
+	resp, err = http.PostForm(srv.URL+"/cli/get-shares", url.Values{"password": {key}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != OK {
+		t.Errorf("/cli/get-shares: %d; want 200", resp.StatusCode)
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// inFlight 是正在处理的请求与连接 (包括 WebSocket, SSH, SMTP), 关闭数据库前要等待它们结束。
+var inFlight sync.WaitGroup
+
+// streams 在关闭服务器时结束，用于结束长连接 (wait, events, WebSocket)。
+// 普通请求不使用它, http.Server.Shutdown 会等待它们处理完毕。
+var streams, stopStreams = context.WithCancel(context.Background())
+
+// ready 为 1 表示服务器正在接受请求，开始关闭时变为 0 (见 readyHandler)。
+var ready int32
+
+// TrackInFlight 记录正在处理的请求，包括已被 WebSocket 接管的连接
+// (http.Server.Shutdown 不会等待这些连接)。
+func TrackInFlight() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		inFlight.Add(1)
+		defer inFlight.Done()
+		c.Next()
+	}
+}
+
+// readyHandler 供负载均衡或 systemd 等检查服务器是否就绪，关闭过程中返回 503.
+func readyHandler(c *gin.Context) {
+	if atomic.LoadInt32(&ready) == 1 {
+		c.JSON(OK, Text{"ready"})
+		return
+	}
+	c.JSON(http.StatusServiceUnavailable, Text{"shutting down"})
+}
+
+// trackConn 把 conn 记入 inFlight, 并在 ctx 结束时关闭 conn (用于 SSH 与 SMTP)。
+// 连接处理完毕后必须调用返回的 done.
+func trackConn(ctx context.Context, conn io.Closer) (done func()) {
+	inFlight.Add(1)
+	finished := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-finished:
+		}
+	}()
+	return func() {
+		close(finished)
+		inFlight.Done()
+	}
+}
+
+// waitTimeout 等待 wg, 超时返回 false.
+func waitTimeout(wg *sync.WaitGroup, timeout time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+// serve 启动 srv, 直至收到 SIGINT 或 SIGTERM, 然后依次：
+//  1. 把 ready 设为 0, 等待 -shutdown-delay (让负载均衡有时间发现);
+//  2. 停止接受新的连接，结束长连接 (wait, events, WebSocket),
+//     等待正在处理的请求 (最多 -shutdown-timeout);
+//  3. 调用 stop 停止后台任务与 SSH, SMTP;
+//  4. 等待剩下的连接与后台任务结束。
+//
+// 返回后即可关闭数据库。
+func serve(srv *http.Server, stop context.CancelFunc, workers *sync.WaitGroup) error {
+	ln, err := net.Listen("tcp", srv.Addr)
+	if err != nil {
+		return err
+	}
+	serveErr := make(chan error, 1)
+	go func() {
+		if srv.TLSConfig != nil {
+			serveErr <- srv.ServeTLS(ln, "", "")
+		} else {
+			serveErr <- srv.Serve(ln)
+		}
+	}()
+	atomic.StoreInt32(&ready, 1)
+
+	sigCtx, stopSignals := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stopSignals()
+	select {
+	case err := <-serveErr:
+		return err
+	case <-sigCtx.Done():
+	}
+	stopSignals() // 再次按 Ctrl-C 即可立即退出
+
+	log.Print("[Shutdown] stop accepting requests")
+	atomic.StoreInt32(&ready, 0)
+	time.Sleep(*shutdownDelay)
+
+	deadline := time.Now().Add(*shutdownTimeout)
+	ctx, cancel := context.WithDeadline(context.Background(), deadline)
+	defer cancel()
+	srv.RegisterOnShutdown(stopStreams)
+	if err := srv.Shutdown(ctx); err != nil {
+		log.Print("[Shutdown] ", err)
+		srv.Close()
+	}
+	stop()
+	if !waitTimeout(&inFlight, time.Until(deadline)) {
+		log.Print("[Shutdown] timeout, some connections are still open")
+	}
+	if !waitTimeout(workers, time.Until(deadline)) {
+		log.Print("[Shutdown] timeout, some background workers are still running")
+	}
+	log.Print("[Shutdown] done")
+	return nil
+}
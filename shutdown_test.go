@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// 关闭服务器时，普通请求应正常完成，长连接则立即结束。
+func TestServeShutdown(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+	t.Cleanup(func() {
+		streams, stopStreams = context.WithCancel(context.Background())
+	})
+
+	started := make(chan struct{}, 2)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/slow", func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		time.Sleep(300 * time.Millisecond)
+		if r.Context().Err() != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		io.WriteString(w, "ok")
+	})
+	mux.HandleFunc("/stream", func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		select {
+		case <-streams.Done():
+			w.WriteHeader(http.StatusServiceUnavailable)
+		case <-time.After(5 * time.Second):
+		}
+	})
+
+	var stopped int32
+	var workers sync.WaitGroup
+	srv := &http.Server{Addr: addr, Handler: mux}
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- serve(srv, func() { atomic.StoreInt32(&stopped, 1) }, &workers)
+	}()
+	for atomic.LoadInt32(&ready) == 0 {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	results := make(map[string]int)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, path := range []string{"/slow", "/stream"} {
+		wg.Add(1)
+		go func(path string) {
+			defer wg.Done()
+			status := 0
+			if resp, err := http.Get("http://" + addr + path); err == nil {
+				resp.Body.Close()
+				status = resp.StatusCode
+			}
+			mu.Lock()
+			results[path] = status
+			mu.Unlock()
+		}(path)
+	}
+	<-started
+	<-started
+
+	p, err := os.FindProcess(os.Getpid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	begin := time.Now()
+	if err := p.Signal(os.Interrupt); err != nil {
+		t.Skip("cannot send SIGINT:", err)
+	}
+	if err := <-serveErr; err != nil {
+		t.Fatal(err)
+	}
+	wg.Wait()
+	if results["/slow"] != OK {
+		t.Errorf("/slow: %d; want 200", results["/slow"])
+	}
+	if results["/stream"] != http.StatusServiceUnavailable {
+		t.Errorf("/stream: %d; want 503", results["/stream"])
+	}
+	if atomic.LoadInt32(&stopped) != 1 {
+		t.Error("stop was not called")
+	}
+	if d := time.Since(begin); d > 2*time.Second {
+		t.Errorf("shutdown took %v", d)
+	}
+}
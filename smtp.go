@@ -0,0 +1,294 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"time"
+
+	"github.com/ahui2016/txt/mydb"
+)
+
+// 一封邮件 (包括邮件头与附件) 的大小上限，消息本身的长度上限是 MsgSizeLimit.
+const smtpDataLimit = 1 << 20
+
+// 每条 SMTP 命令的超时时间
+const smtpCommandTimeout = 2 * time.Minute
+
+var errNoTextPart = errors.New("no text/plain part found")
+
+// smtpRecipient 返回唯一接受的收件地址，即在 -smtp-rcpt 的用户名后加上 "+密钥",
+// 例如 txt@localhost 与密钥 abc 得到 txt+abc@localhost.
+func smtpRecipient(rcpt, secret string) (string, error) {
+	parts := strings.SplitN(rcpt, "@", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", fmt.Errorf("invalid -smtp-rcpt: %q", rcpt)
+	}
+	if secret == "" {
+		return "", fmt.Errorf("-smtp-secret is required")
+	}
+	return parts[0] + "+" + secret + "@" + strings.ToLower(parts[1]), nil
+}
+
+// smtpRcptMatch 判断 addr 是否就是 recipient. 用户名部分 (包含密钥) 必须完全相同,
+// 域名不区分大小写。
+func smtpRcptMatch(addr, recipient string) bool {
+	i, j := strings.LastIndex(addr, "@"), strings.LastIndex(recipient, "@")
+	if i < 0 || j < 0 {
+		return false
+	}
+	local := subtle.ConstantTimeCompare([]byte(addr[:i]), []byte(recipient[:j])) == 1
+	return local && strings.EqualFold(addr[i+1:], recipient[j+1:])
+}
+
+// smtpTries 记录每个 IP 使用错误收件人 (即错误的密钥) 的次数。
+// 与 ipTryCount 分开，且不限制全部 IP 的次数，以免发往错误地址的邮件导致主密码与密钥被封锁。
+var smtpTries = newTryCounter(false)
+
+// smtpListenAndServe 启动 SMTP 服务器，直至 ctx 结束。只接受发给 recipient 的邮件。
+func smtpListenAndServe(ctx context.Context, addr, recipient string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	log.Print("[SMTP] ", addr)
+	return smtpServe(ctx, ln, recipient)
+}
+
+// smtpServe 在 ln 上接受连接，直至 ctx 结束。
+func smtpServe(ctx context.Context, ln net.Listener, recipient string) error {
+	go func() {
+		<-ctx.Done()
+		ln.Close()
+	}()
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		go smtpServeConn(ctx, conn, recipient)
+	}
+}
+
+// smtpServeConn 实现 SMTP 协议中足够接收邮件的部分 (RFC 5321), 不支持 STARTTLS 与 AUTH,
+// 因此应该只监听本机或内网地址。
+func smtpServeConn(ctx context.Context, conn net.Conn, recipient string) {
+	defer conn.Close()
+	defer trackConn(ctx, conn)()
+	tc := textproto.NewConn(conn)
+	ip, _, _ := net.SplitHostPort(conn.RemoteAddr().String())
+	reply := func(code int, msg string) {
+		_ = tc.PrintfLine("%d %s", code, msg)
+	}
+
+	var from string
+	var rcptOK bool
+	reset := func() {
+		from = ""
+		rcptOK = false
+	}
+
+	if needSetup() {
+		reply(421, errSetupRequired.Error())
+		return
+	}
+	reply(220, "txt ESMTP ready")
+	for {
+		_ = conn.SetDeadline(time.Now().Add(smtpCommandTimeout))
+		line, err := tc.ReadLine()
+		if err != nil {
+			return
+		}
+		parts := strings.SplitN(line, " ", 2)
+		verb, arg := strings.ToUpper(parts[0]), ""
+		if len(parts) == 2 {
+			arg = strings.TrimSpace(parts[1])
+		}
+
+		switch verb {
+		case "HELO":
+			reset()
+			reply(250, "txt")
+		case "EHLO":
+			reset()
+			_ = tc.PrintfLine("250-txt")
+			_ = tc.PrintfLine("250-8BITMIME")
+			reply(250, fmt.Sprintf("SIZE %d", smtpDataLimit))
+		case "MAIL":
+			addr, ok := smtpPath(arg, "FROM:")
+			if !ok {
+				reply(501, "syntax: MAIL FROM:<address>")
+				continue
+			}
+			reset()
+			from = addr
+			if from == "" {
+				from = "<>" // bounce
+			}
+			reply(250, "OK")
+		case "RCPT":
+			addr, ok := smtpPath(arg, "TO:")
+			if !ok {
+				reply(501, "syntax: RCPT TO:<address>")
+				continue
+			}
+			if from == "" {
+				reply(503, "need MAIL first")
+				continue
+			}
+			if err := smtpTries.check(ip); err != nil {
+				reply(550, err.Error())
+				continue
+			}
+			ok = smtpRcptMatch(addr, recipient)
+			smtpTries.tried(ip, ok)
+			if !ok {
+				reply(550, "no such user")
+				continue
+			}
+			rcptOK = true
+			reply(250, "OK")
+		case "DATA":
+			if !rcptOK {
+				reply(503, "need RCPT first")
+				continue
+			}
+			reply(354, "end data with <CR><LF>.<CR><LF>")
+			dr := tc.DotReader()
+			data, err := io.ReadAll(io.LimitReader(dr, smtpDataLimit+1))
+			if err != nil {
+				return
+			}
+			reset()
+			if len(data) > smtpDataLimit {
+				// 丢弃剩余的内容，以便继续处理下一条命令。
+				if _, err := io.Copy(io.Discard, dr); err != nil {
+					return
+				}
+				reply(552, "message too large")
+				continue
+			}
+			if err := insertMail(data, ip); err != nil {
+				code := 554
+				if errors.Is(err, mydb.ErrMsgTooLong) {
+					code = 552
+				}
+				reply(code, err.Error())
+				continue
+			}
+			reply(250, "OK")
+		case "RSET":
+			reset()
+			reply(250, "OK")
+		case "NOOP":
+			reply(250, "OK")
+		case "QUIT":
+			reply(221, "bye")
+			return
+		default:
+			reply(502, "command not implemented")
+		}
+	}
+}
+
+// smtpPath 从 "FROM:<a@b.c> SIZE=123" 这样的参数中取出邮件地址。
+func smtpPath(arg, prefix string) (addr string, ok bool) {
+	if len(arg) < len(prefix) || !strings.EqualFold(arg[:len(prefix)], prefix) {
+		return "", false
+	}
+	arg = strings.TrimSpace(arg[len(prefix):])
+	if !strings.HasPrefix(arg, "<") {
+		return "", false
+	}
+	end := strings.Index(arg, ">")
+	if end < 0 {
+		return "", false
+	}
+	return arg[1:end], true
+}
+
+// insertMail 取出邮件中的纯文本内容，作为一条新的暂存消息保存。
+func insertMail(data []byte, ip string) error {
+	if *demo && !demoCounter.add(ip, *demoMaxMsg) {
+		return errors.New("Demo Mode (演示模式) 已达到消息数量上限，请等待数据重置。")
+	}
+	m, err := mail.ReadMessage(bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	body, err := textPart(textproto.MIMEHeader(m.Header), m.Body)
+	if err != nil {
+		return err
+	}
+	msg := strings.TrimSpace(strings.ReplaceAll(body, "\r\n", "\n"))
+	if msg == "" {
+		return fmt.Errorf("the message is empty")
+	}
+	tm, err := db.NewTxtMsg(msg)
+	if err != nil {
+		return err
+	}
+	return db.InsertTxtMsg(tm)
+}
+
+// textPart 返回第一个 text/plain 部分的内容 (已解码), 会递归查找 multipart 里的每一部分。
+// 只接受 UTF-8 (或 US-ASCII) 编码。
+func textPart(header textproto.MIMEHeader, body io.Reader) (string, error) {
+	contentType := header.Get("Content-Type")
+	if contentType == "" {
+		contentType = "text/plain"
+	}
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return "", err
+	}
+
+	if strings.HasPrefix(mediaType, "multipart/") {
+		mr := multipart.NewReader(body, params["boundary"])
+		for {
+			part, err := mr.NextRawPart()
+			if err == io.EOF {
+				return "", errNoTextPart
+			}
+			if err != nil {
+				return "", err
+			}
+			text, err := textPart(part.Header, part)
+			if err == errNoTextPart {
+				continue
+			}
+			return text, err
+		}
+	}
+	if mediaType != "text/plain" {
+		return "", errNoTextPart
+	}
+	if charset := strings.ToLower(params["charset"]); charset != "" &&
+		charset != "utf-8" && charset != "us-ascii" {
+		return "", fmt.Errorf("unsupported charset: %s", charset)
+	}
+
+	switch strings.ToLower(header.Get("Content-Transfer-Encoding")) {
+	case "quoted-printable":
+		body = quotedprintable.NewReader(body)
+	case "base64":
+		body = base64.NewDecoder(base64.StdEncoding, body) // 会自动忽略换行符
+	}
+	text, err := io.ReadAll(body)
+	return string(text), err
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/smtp"
+	"strings"
+	"testing"
+
+	"github.com/ahui2016/txt/mydb"
+)
+
+// startSMTP 在本机的随机端口启动 SMTP 服务器，返回其地址。
+func startSMTP(t *testing.T, recipient string) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_ = smtpServe(ctx, ln, recipient)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+	return ln.Addr().String()
+}
+
+func TestSMTP(t *testing.T) {
+	newTestDB(t)
+	recipient, err := smtpRecipient("txt@localhost", "SeCret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := startSMTP(t, recipient)
+	mail := "Subject: code\r\nContent-Type: text/plain; charset=utf-8\r\n\r\nmail to "
+
+	for _, tc := range []struct {
+		to     string
+		errMsg string // 为空表示应该成功
+	}{
+		{"txt+SeCret@localhost", ""},
+		{"txt+SeCret@LOCALHOST", ""}, // 域名不区分大小写
+		{"txt+secret@localhost", "550"},
+		{"txt+SeCret2@localhost", "550"},
+		{"txt@localhost", "550"},
+	} {
+		err := smtp.SendMail(addr, nil, "me@example.com", []string{tc.to}, []byte(mail+tc.to+"\r\n"))
+		if tc.errMsg == "" && err != nil {
+			t.Errorf("%s: %v", tc.to, err)
+		}
+		if tc.errMsg != "" && (err == nil || !strings.HasPrefix(err.Error(), tc.errMsg)) {
+			t.Errorf("%s: %v; want %s", tc.to, err, tc.errMsg)
+		}
+	}
+	items, err := db.CliGetTxtMsg(mydb.TempBucket, 1, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 2 || items[0].Msg != "mail to txt+SeCret@LOCALHOST" {
+		t.Errorf("got %d messages: %+v", len(items), items)
+	}
+}
+
+func TestSMTPSetupRequired(t *testing.T) {
+	newTestDB(t)
+	db = mydb.NewMemDB() // 尚未设置主密码
+	recipient, _ := smtpRecipient("txt@localhost", "secret")
+	addr := startSMTP(t, recipient)
+	err := smtp.SendMail(addr, nil, "me@example.com", []string{recipient}, []byte("Subject: x\r\n\r\nx\r\n"))
+	if err == nil || !strings.HasPrefix(err.Error(), "421") {
+		t.Errorf("got %v; want 421", err)
+	}
+}
+
+// 错误收件人的次数只封锁 SMTP, 不影响主密码与密钥。
+func TestSMTPTries(t *testing.T) {
+	newTestDB(t)
+	recipient, _ := smtpRecipient("txt@localhost", "secret")
+	addr := startSMTP(t, recipient)
+	send := func(to string) error {
+		t.Helper()
+		return smtp.SendMail(addr, nil, "me@example.com", []string{to}, []byte("Subject: x\r\n\r\nx "+to+"\r\n"))
+	}
+	for i := 0; i < *passwordMaxTry; i++ {
+		if err := send("txt+wrong@localhost"); err == nil || !strings.HasPrefix(err.Error(), "550") {
+			t.Fatalf("wrong recipient %d: %v; want 550", i, err)
+		}
+	}
+	if err := send(recipient); err == nil || !strings.Contains(err.Error(), "no more try") {
+		t.Errorf("after too many wrong recipients: %v; want no more try", err)
+	}
+	if n := ipTryCount.get("127.0.0.1") + ipTryCount.get("all"); n > 0 {
+		t.Errorf("got %d tries of the main password; want none", n)
+	}
+}
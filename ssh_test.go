@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/ahui2016/txt/mydb"
+	"golang.org/x/crypto/ssh"
+)
+
+func newSSHSigner(t *testing.T) ssh.Signer {
+	t.Helper()
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signer
+}
+
+// startSSH 在本机的随机端口启动 SSH 服务器，返回其地址与服务器的公钥。
+func startSSH(t *testing.T) (addr string, hostKey ssh.PublicKey) {
+	t.Helper()
+	hostSigner := newSSHSigner(t)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_ = sshServe(ctx, ln, sshServerConfig(hostSigner))
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+	return ln.Addr().String(), hostSigner.PublicKey()
+}
+
+// sshRun 使用 signer 登录并执行一个命令，返回 stdout.
+func sshRun(t *testing.T, addr string, hostKey ssh.PublicKey, signer ssh.Signer, command string) (string, error) {
+	t.Helper()
+	client, err := ssh.Dial("tcp", addr, &ssh.ClientConfig{
+		User:            "txt",
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
+		HostKeyCallback: ssh.FixedHostKey(hostKey),
+	})
+	if err != nil {
+		return "", err
+	}
+	defer client.Close()
+	session, err := client.NewSession()
+	if err != nil {
+		return "", err
+	}
+	defer session.Close()
+	out, err := session.Output(command)
+	return string(out), err
+}
+
+func TestSSH(t *testing.T) {
+	srv, key := newTestServer(t)
+	insertTestMsgs(t, 2)
+	addr, hostKey := startSSH(t)
+	signer := newSSHSigner(t)
+
+	// 未登记的公钥不能登录
+	if _, err := sshRun(t, addr, hostKey, signer, "list"); err == nil {
+		t.Fatal("login with an unregistered key: want an error")
+	}
+	publicKey := string(ssh.MarshalAuthorizedKey(signer.PublicKey()))
+	resp, err := http.PostForm(srv.URL+"/cli/add-ssh-key", url.Values{"password": {key}, "public_key": {publicKey}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != OK {
+		t.Fatalf("add ssh key: %d", resp.StatusCode)
+	}
+	// 只保存密钥的指纹
+	keys, err := db.GetSSHKeys()
+	if err != nil || len(keys) != 1 || keys[0].KeyFingerprint == "" || strings.Contains(keys[0].KeyFingerprint, key) {
+		t.Fatalf("stored keys: %+v, %v", keys, err)
+	}
+
+	for _, tc := range []struct {
+		command string
+		want    string
+	}{
+		{"list", "m1"},
+		{"send hello ssh", ""},
+		{"get", "hello ssh"},
+		{"list -n 1", "hello ssh"},
+	} {
+		out, err := sshRun(t, addr, hostKey, signer, tc.command)
+		if err != nil || !strings.Contains(out, tc.want) {
+			t.Errorf("%s: got %q, %v; want %q", tc.command, out, err, tc.want)
+		}
+	}
+	if _, err := sshRun(t, addr, hostKey, signer, "get nope"); err == nil {
+		t.Error("get nope: want an error")
+	}
+	items, err := db.CliGetTxtMsg(mydb.TempBucket, 1, 10)
+	if err != nil || len(items) != 3 || items[0].Msg != "hello ssh" {
+		t.Errorf("got %d messages, %v", len(items), err)
+	}
+
+	// 更换密钥后，原来登记的公钥失效
+	if err := db.GenNewKey(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sshRun(t, addr, hostKey, signer, "list"); err == nil {
+		t.Error("login after the key is changed: want an error")
+	}
+}
+
+// 尚未设置主密码时拒绝一切登录。
+func TestSSHNeedSetup(t *testing.T) {
+	newTestDB(t)
+	addr, hostKey := startSSH(t)
+	signer := newSSHSigner(t)
+	db = mydb.NewMemDB()
+	config := db.GetConfig()
+	err := db.PutSSHKey(mydb.SSHKey{
+		Fingerprint:    ssh.FingerprintSHA256(signer.PublicKey()),
+		PublicKey:      string(ssh.MarshalAuthorizedKey(signer.PublicKey())),
+		KeyFingerprint: keyFingerprint(config.Key),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sshRun(t, addr, hostKey, signer, "list"); err == nil {
+		t.Error("login before setup: want an error")
+	}
+}
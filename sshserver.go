@@ -0,0 +1,469 @@
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/ahui2016/txt/model"
+	"github.com/ahui2016/txt/mydb"
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/ssh"
+)
+
+// SSH 服务器的私钥，与数据库保存在同一个文件夹。
+const sshHostKeyFileName = "ssh_host_ed25519_key"
+
+// 客户端必须在这段时间内完成握手与登录
+const sshHandshakeTimeout = 30 * time.Second
+
+const sshUsage = `Usage: ssh -p PORT txt@HOST command [arguments]
+
+Commands:
+  send [-device NAME] [message]  send a message (read from stdin if omitted)
+  get [alias|index]              print a message
+  list [-n 5] [-p] [-start 1]    list recent messages (-p: permanent messages)
+  search [-t|-p] keyword         search messages
+  toggle alias|index             move a message between temporary and permanent
+  delete alias|index             delete a message
+  alias                          list all aliases
+  alias index|alias new-alias    set the alias of a message
+  alias -d index|alias           remove the alias of a message
+  help                           show this help
+`
+
+// sshSession 是一次 SSH 命令的输入输出。
+type sshSession struct {
+	ch ssh.Channel
+	ip string
+}
+
+// sshCommands 是 SSH 服务器支持的命令，与客户端子命令 (见 cli.go) 相同。
+var sshCommands = map[string]func(s sshSession, args []string) error{
+	"send":   sshSend,
+	"add":    sshSend,
+	"get":    sshGet,
+	"list":   sshList,
+	"search": sshSearch,
+	"toggle": sshToggle,
+	"delete": sshDelete,
+	"alias":  sshAlias,
+	"help":   sshHelp,
+}
+
+func addSSHKeyHandler(c *gin.Context) {
+	type form struct {
+		PublicKey string `form:"public_key" binding:"required"`
+		Name      string `form:"name"`
+	}
+	var f form
+	if BindCheck(c, &f) {
+		return
+	}
+	pub, comment, _, _, err := ssh.ParseAuthorizedKey([]byte(f.PublicKey))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, Text{"invalid public key: " + err.Error()})
+		return
+	}
+	if f.Name == "" {
+		f.Name = comment
+	}
+	// 已通过 CliCheckKey, 因此请求中的就是当前的密钥。
+	key := model.SSHKey{
+		Fingerprint:    ssh.FingerprintSHA256(pub),
+		Name:           f.Name,
+		PublicKey:      strings.TrimSpace(string(ssh.MarshalAuthorizedKey(pub))),
+		KeyFingerprint: keyFingerprint(keyFromRequest(c)),
+	}
+	if checkErr(c, db.PutSSHKey(key)) {
+		return
+	}
+	key, err = db.GetSSHKey(key.Fingerprint)
+	if checkErr(c, err) {
+		return
+	}
+	c.JSON(OK, key)
+}
+
+// keyFingerprint 返回日常操作密钥的 SHA256 指纹，
+// 用来判断登记公钥之后密钥是否已更换，而不必保存密钥本身。
+func keyFingerprint(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return "SHA256:" + base64.RawStdEncoding.EncodeToString(sum[:])
+}
+
+func getSSHKeysHandler(c *gin.Context) {
+	keys, err := db.GetSSHKeys()
+	if checkErr(c, err) {
+		return
+	}
+	if keys == nil {
+		keys = []model.SSHKey{}
+	}
+	c.JSON(OK, keys)
+}
+
+func deleteSSHKeyHandler(c *gin.Context) {
+	type form struct {
+		Fingerprint string `form:"fingerprint" binding:"required"`
+	}
+	var f form
+	if BindCheck(c, &f) {
+		return
+	}
+	checkErr(c, db.DeleteSSHKey(f.Fingerprint))
+}
+
+// sshHostKey 读取服务器的私钥，如果不存在则生成一个新的 ed25519 私钥。
+func sshHostKey() (ssh.Signer, error) {
+	keyPath := filepath.Join(filepath.Dir(getDBPath()), sshHostKeyFileName)
+	data, err := os.ReadFile(keyPath)
+	if errors.Is(err, os.ErrNotExist) {
+		_, priv, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		der, err := x509.MarshalPKCS8PrivateKey(priv)
+		if err != nil {
+			return nil, err
+		}
+		data = pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+		if err := os.WriteFile(keyPath, data, 0600); err != nil {
+			return nil, err
+		}
+	} else if err != nil {
+		return nil, err
+	}
+	return ssh.ParsePrivateKey(data)
+}
+
+// sshServerConfig 只接受已登记的公钥 (见 addSSHKeyHandler), 并且登记时使用的
+// 密钥必须是当前的密钥且仍然有效，因此更换密钥后原来登记的公钥都会失效。用户名可以任意。
+// 尚未设置主密码时拒绝一切登录 (见 CheckSetup)。
+func sshServerConfig(hostKey ssh.Signer) *ssh.ServerConfig {
+	config := &ssh.ServerConfig{
+		PublicKeyCallback: func(_ ssh.ConnMetadata, pub ssh.PublicKey) (*ssh.Permissions, error) {
+			if needSetup() {
+				return nil, errSetupRequired
+			}
+			key, err := db.GetSSHKey(ssh.FingerprintSHA256(pub))
+			if err != nil {
+				return nil, fmt.Errorf("unknown public key")
+			}
+			current := db.GetConfig().Key
+			if subtle.ConstantTimeCompare([]byte(key.KeyFingerprint), []byte(keyFingerprint(current))) != 1 {
+				return nil, fmt.Errorf("the key has been changed, register the public key again")
+			}
+			if err := db.CheckKey(current); err != nil {
+				return nil, err
+			}
+			return nil, nil
+		},
+	}
+	config.AddHostKey(hostKey)
+	return config
+}
+
+// sshListenAndServe 启动 SSH 服务器，直至 ctx 结束。
+func sshListenAndServe(ctx context.Context, addr string) error {
+	hostKey, err := sshHostKey()
+	if err != nil {
+		return err
+	}
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	log.Print("[SSH] ", addr)
+	return sshServe(ctx, ln, sshServerConfig(hostKey))
+}
+
+// sshServe 在 ln 上接受连接，直至 ctx 结束。
+func sshServe(ctx context.Context, ln net.Listener, config *ssh.ServerConfig) error {
+	go func() {
+		<-ctx.Done()
+		ln.Close()
+	}()
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		go sshServeConn(ctx, conn, config)
+	}
+}
+
+func sshServeConn(ctx context.Context, conn net.Conn, config *ssh.ServerConfig) {
+	defer conn.Close()
+	defer trackConn(ctx, conn)()
+	_ = conn.SetDeadline(time.Now().Add(sshHandshakeTimeout))
+	sconn, chans, reqs, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		return
+	}
+	defer sconn.Close()
+	_ = conn.SetDeadline(time.Time{})
+	go ssh.DiscardRequests(reqs)
+
+	ip, _, _ := net.SplitHostPort(conn.RemoteAddr().String())
+	for newCh := range chans {
+		if newCh.ChannelType() != "session" {
+			_ = newCh.Reject(ssh.UnknownChannelType, "unknown channel type")
+			continue
+		}
+		ch, requests, err := newCh.Accept()
+		if err != nil {
+			continue
+		}
+		go sshServeSession(sshSession{ch: ch, ip: ip}, requests)
+	}
+}
+
+// sshServeSession 执行 exec 请求中的命令，没有命令 (shell) 时显示帮助。
+// 每个 session 只执行一个命令。
+func sshServeSession(s sshSession, requests <-chan *ssh.Request) {
+	defer s.ch.Close()
+	for req := range requests {
+		switch req.Type {
+		case "exec":
+			var payload struct{ Command string }
+			if err := ssh.Unmarshal(req.Payload, &payload); err != nil {
+				_ = req.Reply(false, nil)
+				continue
+			}
+			_ = req.Reply(true, nil)
+			s.exit(s.run(payload.Command))
+			return
+		case "shell":
+			_ = req.Reply(true, nil)
+			fmt.Fprint(s.ch, sshUsage)
+			s.exit(nil)
+			return
+		default:
+			if req.WantReply {
+				_ = req.Reply(false, nil)
+			}
+		}
+	}
+}
+
+func (s sshSession) run(command string) error {
+	args := strings.Fields(command)
+	if len(args) == 0 {
+		args = []string{"help"}
+	}
+	cmd, ok := sshCommands[args[0]]
+	if !ok {
+		return fmt.Errorf("unknown command: %s (see \"help\")", args[0])
+	}
+	err := cmd(s, args[1:])
+	if errors.Is(err, mydb.ErrNoResult) {
+		return errors.New("not found")
+	}
+	return err
+}
+
+// exit 把错误信息写入 stderr 并发送 exit-status.
+func (s sshSession) exit(err error) {
+	var status uint32
+	if err != nil {
+		fmt.Fprintln(s.ch.Stderr(), "Error:", err)
+		status = 1
+	}
+	_, _ = s.ch.SendRequest("exit-status", false,
+		ssh.Marshal(struct{ Status uint32 }{status}))
+}
+
+func (s sshSession) flagSet(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(s.ch.Stderr())
+	return fs
+}
+
+// checkWritable 相当于 CheckWritable 与 DemoMsgLimit (newMsg 为 true 时)。
+func (s sshSession) checkWritable(newMsg bool) error {
+	if *readonly {
+		return errors.New("Read-only Mode (只读模式) 不可修改数据。")
+	}
+	if newMsg && *demo && !demoCounter.add(s.ip, *demoMaxMsg) {
+		return errors.New("Demo Mode (演示模式) 已达到消息数量上限，请等待数据重置。")
+	}
+	return nil
+}
+
+func sshHelp(s sshSession, _ []string) error {
+	fmt.Fprint(s.ch, sshUsage)
+	return nil
+}
+
+func sshSend(s sshSession, args []string) error {
+	fs := s.flagSet("send")
+	device := fs.String("device", "", "the name of this device (optional)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := s.checkWritable(true); err != nil {
+		return err
+	}
+	if *device != "" {
+		if _, err := db.GetDevice(*device); err != nil {
+			return fmt.Errorf("unknown device: %s", *device)
+		}
+	}
+	msg := strings.Join(fs.Args(), " ")
+	if msg == "" {
+		// 多读一个字节，以便让 NewTxtMsg 判断消息是否太长。
+		limit := int64(db.GetConfig().MsgSizeLimit) + 1
+		data, err := io.ReadAll(io.LimitReader(s.ch, limit))
+		if err != nil {
+			return err
+		}
+		msg = strings.TrimRight(string(data), "\r\n")
+	}
+	if msg == "" {
+		return fmt.Errorf("the message is empty")
+	}
+	tm, err := db.NewTxtMsg(msg)
+	if err != nil {
+		return err
+	}
+	tm.Device = *device
+	return db.InsertTxtMsg(tm)
+}
+
+func sshGet(s sshSession, args []string) error {
+	a_or_i := "t1"
+	if len(args) > 0 {
+		a_or_i = args[0]
+	}
+	tm, err := db.GetByAliasIndex(a_or_i)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(s.ch, tm.Msg)
+	return nil
+}
+
+func sshList(s sshSession, args []string) error {
+	fs := s.flagSet("list")
+	n := fs.Int("n", 5, "how many messages to list")
+	perm := fs.Bool("p", false, "list permanent messages")
+	start := fs.Int("start", 1, "start from this index")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	bucket := mydb.TempBucket
+	if *perm {
+		bucket = mydb.PermBucket
+	}
+	items, err := db.CliGetTxtMsg(bucket, *start, *n)
+	if err != nil {
+		return err
+	}
+	printItems(s.ch, items)
+	return nil
+}
+
+func sshSearch(s sshSession, args []string) error {
+	fs := s.flagSet("search")
+	temp := fs.Bool("t", false, "search temporary messages only")
+	perm := fs.Bool("p", false, "search permanent messages only")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		return fmt.Errorf("usage: search [-t|-p] keyword")
+	}
+	var buckets []string
+	if *temp {
+		buckets = append(buckets, mydb.TempBucket)
+	}
+	if *perm {
+		buckets = append(buckets, mydb.PermBucket)
+	}
+	items, err := db.SearchTxtMsg(strings.Join(fs.Args(), " "), buckets)
+	if err != nil {
+		return err
+	}
+	printItems(s.ch, items)
+	return nil
+}
+
+func sshToggle(s sshSession, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: toggle alias|index")
+	}
+	if err := s.checkWritable(false); err != nil {
+		return err
+	}
+	tm, err := db.GetByAliasIndex(args[0])
+	if err != nil {
+		return err
+	}
+	after, err := db.ToggleCat(tm)
+	if err != nil {
+		return err
+	}
+	after.Index = 1
+	printItems(s.ch, []model.TxtMsg{after})
+	return nil
+}
+
+func sshDelete(s sshSession, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: delete alias|index")
+	}
+	if err := s.checkWritable(false); err != nil {
+		return err
+	}
+	return db.CliDeleteTxtMsg(args[0])
+}
+
+func sshAlias(s sshSession, args []string) error {
+	fs := s.flagSet("alias")
+	remove := fs.Bool("d", false, "remove the alias")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	switch {
+	case fs.NArg() == 0 && !*remove:
+		aliases, err := db.GetAllAliases()
+		if err != nil {
+			return err
+		}
+		for _, alias := range aliases {
+			fmt.Fprintln(s.ch, alias.ID)
+		}
+		return nil
+	case fs.NArg() == 1 && *remove:
+		if err := s.checkWritable(false); err != nil {
+			return err
+		}
+		return db.UpdateAlias(fs.Arg(0), "")
+	case fs.NArg() == 2 && !*remove:
+		if err := s.checkWritable(false); err != nil {
+			return err
+		}
+		return db.UpdateAlias(fs.Arg(0), fs.Arg(1))
+	}
+	return fmt.Errorf("usage: alias [index|alias new-alias] or alias -d index|alias")
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"log"
+	"math/big"
+	"net"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/ahui2016/txt/client"
+	"github.com/ahui2016/txt/util"
+)
+
+// 自签名证书保存在 appConfigFolder 里。
+const (
+	selfSignedCertFileName = "tls-cert.pem"
+	selfSignedKeyFileName  = "tls-key.pem"
+	selfSignedValidFor     = 5 * 365 * 24 * time.Hour
+)
+
+// certReloader 保存当前使用的证书，收到 SIGHUP 时重新读取证书文件，不需要重启服务器。
+type certReloader struct {
+	sync.RWMutex
+	certFile string
+	keyFile  string
+	cert     *tls.Certificate
+}
+
+func newCertReloader(certFile, keyFile string) (*certReloader, error) {
+	r := &certReloader{certFile: certFile, keyFile: keyFile}
+	if err := r.reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *certReloader) reload() error {
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return err
+	}
+	r.Lock()
+	r.cert = &cert
+	r.Unlock()
+	log.Print("[TLS] certificate fingerprint (SHA-256): ", client.Fingerprint(cert.Certificate[0]))
+	return nil
+}
+
+func (r *certReloader) getCertificate(_ *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.RLock()
+	defer r.RUnlock()
+	return r.cert, nil
+}
+
+// watchSIGHUP 每次收到 SIGHUP 都重新读取证书，读取失败时继续使用原来的证书。
+func (r *certReloader) watchSIGHUP() {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGHUP)
+	for range ch {
+		if err := r.reload(); err != nil {
+			log.Print("[TLS] reload failed, keep the old certificate: ", err)
+		}
+	}
+}
+
+// tlsConfig 根据 -tls-cert, -tls-key, -tls-self-signed 返回 TLS 设置,
+// 没有启用 TLS 时返回 nil.
+func tlsConfig() (*tls.Config, error) {
+	certFile, keyFile := *tlsCert, *tlsKey
+	switch {
+	case *tlsSelfSigned && (certFile != "" || keyFile != ""):
+		return nil, fmt.Errorf("-tls-self-signed cannot be used with -tls-cert or -tls-key")
+	case *tlsSelfSigned:
+		certFile = filepath.Join(appConfigFolder(), selfSignedCertFileName)
+		keyFile = filepath.Join(appConfigFolder(), selfSignedKeyFileName)
+		if util.PathIsNotExist(certFile) || util.PathIsNotExist(keyFile) {
+			if err := genSelfSignedCert(certFile, keyFile, selfSignedHosts()); err != nil {
+				return nil, err
+			}
+			log.Print("[TLS] generated ", certFile)
+		}
+	case certFile == "" && keyFile == "":
+		return nil, nil
+	case certFile == "" || keyFile == "":
+		return nil, fmt.Errorf("-tls-cert and -tls-key must be used together")
+	}
+
+	reloader, err := newCertReloader(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	go reloader.watchSIGHUP()
+	return &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: reloader.getCertificate,
+	}, nil
+}
+
+// selfSignedHosts 返回自签名证书包含的域名与 IP: localhost, -addr 的主机与 -tls-hosts.
+func selfSignedHosts() []string {
+	hosts := []string{"localhost", "127.0.0.1", "::1"}
+	add := func(host string) {
+		for _, h := range hosts {
+			if h == host {
+				return
+			}
+		}
+		hosts = append(hosts, host)
+	}
+	if host, _, err := net.SplitHostPort(*addr); err == nil && host != "" && host != "0.0.0.0" && host != "::" {
+		add(host)
+	}
+	for _, host := range strings.Split(*tlsHosts, ",") {
+		if host = strings.TrimSpace(host); host != "" {
+			add(host)
+		}
+	}
+	return hosts
+}
+
+// genSelfSignedCert 生成一个自签名证书 (ECDSA P-256), 保存为 PEM 文件。
+func genSelfSignedCert(certFile, keyFile string, hosts []string) error {
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	template := x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{Organization: []string{"txt self-signed"}},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(selfSignedValidFor),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &priv.PublicKey, priv)
+	if err != nil {
+		return err
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(priv)
+	if err != nil {
+		return err
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER})
+	if err := os.WriteFile(keyFile, keyPEM, 0600); err != nil {
+		return err
+	}
+	return os.WriteFile(certFile, certPEM, 0644)
+}
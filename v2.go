@@ -0,0 +1,289 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/ahui2016/txt/model"
+	"github.com/ahui2016/txt/mydb"
+	"github.com/gin-gonic/gin"
+)
+
+// v2 API 的错误代码，供程序判断，不会随意改变。
+const (
+	codeBadRequest    = "bad_request"
+	codeWrongKey      = "wrong_key"
+	codeKeyRequired   = "key_required"
+	codeKeyExpired    = "key_expired"
+	codeTooManyTries  = "too_many_tries"
+	codeNotFound      = "not_found"
+	codeAliasExists   = "alias_exists"
+	codeMsgTooLong    = "msg_too_long"
+	codeSameAsLast    = "same_as_last"
+	codeUnknownDevice = "unknown_device"
+	codeSetupRequired = "setup_required"
+	codeReadOnly      = "read_only"
+	codeRateLimited   = "rate_limited"
+	codeMsgLimit      = "msg_limit"
+	codeTrimmed       = "changes_trimmed"
+	codeInternal      = "internal_error"
+)
+
+// APIError 是 v2 API 的错误响应。
+type APIError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func v2Abort(c *gin.Context, status int, code, message string) {
+	c.AbortWithStatusJSON(status, APIError{Code: code, Message: message})
+}
+
+// errorCode 把数据库的错误转换为相应的状态码与错误代码。
+func errorCode(err error) (status int, code string) {
+	switch {
+	case errors.Is(err, mydb.ErrNoResult):
+		return http.StatusNotFound, codeNotFound
+	case errors.Is(err, mydb.ErrKeyExists):
+		return http.StatusConflict, codeAliasExists
+	case errors.Is(err, mydb.ErrBadAlias):
+		return http.StatusBadRequest, codeBadRequest
+	case errors.Is(err, mydb.ErrMsgTooLong):
+		return http.StatusRequestEntityTooLarge, codeMsgTooLong
+	case errors.Is(err, mydb.ErrSameAsLast):
+		return http.StatusConflict, codeSameAsLast
+	case errors.Is(err, mydb.ErrWrongKey):
+		return http.StatusUnauthorized, codeWrongKey
+	case errors.Is(err, mydb.ErrKeyExpired):
+		return http.StatusUnauthorized, codeKeyExpired
+	case errors.Is(err, mydb.ErrChangesTrimmed):
+		return http.StatusGone, codeTrimmed
+	}
+	return http.StatusInternalServerError, codeInternal
+}
+
+// v2CheckErr 把数据库的错误转换为相应的状态码与错误代码，返回 true 表示有错误。
+func v2CheckErr(c *gin.Context, err error) bool {
+	if err == nil {
+		return false
+	}
+	status, code := errorCode(err)
+	v2Abort(c, status, code, err.Error())
+	return true
+}
+
+// v2Bind 与 BindCheck 相同，但使用 v2 的错误格式。
+func v2Bind(c *gin.Context, obj interface{}) bool {
+	if err := c.ShouldBind(obj); err != nil {
+		v2Abort(c, http.StatusBadRequest, codeBadRequest, err.Error())
+		return true
+	}
+	return false
+}
+
+// V2CheckKey 检查密钥，与 CliCheckKey 的区别是使用 v2 的错误格式。
+// 尚未设置主密码时拒绝一切请求 (见 CheckSetup)。
+func V2CheckKey() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if needSetup() {
+			v2Abort(c, http.StatusServiceUnavailable, codeSetupRequired, errSetupRequired.Error())
+			return
+		}
+		if checkKeyAndIP(c, keyFromRequest(c), v2Abort) {
+			return
+		}
+		c.Next()
+	}
+}
+
+// v2Bucket 把 "temp", "perm" 转换为 bucket 名称。
+func v2Bucket(name string) (bucket string, ok bool) {
+	switch strings.ToLower(name) {
+	case "", "temp":
+		return mydb.TempBucket, true
+	case "perm":
+		return mydb.PermBucket, true
+	}
+	return "", false
+}
+
+// v2ListMessages 列出消息 (bucket, index, limit), 如果有参数 q 则查找消息。
+func v2ListMessages(c *gin.Context) {
+	type query struct {
+		Q      string `form:"q"`
+		Bucket string `form:"bucket"`
+		Index  int    `form:"index"`
+		Limit  int    `form:"limit"`
+	}
+	var q query
+	if v2Bind(c, &q) {
+		return
+	}
+	if q.Q != "" {
+		var buckets []string
+		if q.Bucket != "" {
+			bucket, ok := v2Bucket(q.Bucket)
+			if !ok {
+				v2Abort(c, http.StatusBadRequest, codeBadRequest, "unknown bucket: "+q.Bucket)
+				return
+			}
+			buckets = append(buckets, bucket)
+		}
+		items, err := db.SearchTxtMsg(q.Q, buckets)
+		if v2CheckErr(c, err) {
+			return
+		}
+		c.JSON(OK, nonNilItems(items))
+		return
+	}
+
+	bucket, ok := v2Bucket(q.Bucket)
+	if !ok {
+		v2Abort(c, http.StatusBadRequest, codeBadRequest, "unknown bucket: "+q.Bucket)
+		return
+	}
+	if q.Limit <= 0 {
+		q.Limit = db.GetConfig().EveryPageLimit
+	}
+	items, err := db.CliGetTxtMsg(bucket, q.Index, q.Limit)
+	if v2CheckErr(c, err) {
+		return
+	}
+	c.JSON(OK, nonNilItems(items))
+}
+
+// nonNilItems 确保没有消息时返回 [] 而不是 null.
+func nonNilItems(items []model.TxtMsg) []model.TxtMsg {
+	if items == nil {
+		return []model.TxtMsg{}
+	}
+	return items
+}
+
+func v2CreateMessage(c *gin.Context) {
+	type form struct {
+		Msg    string `json:"msg" form:"msg" binding:"required"`
+		Device string `json:"device" form:"device"`
+	}
+	var f form
+	if v2Bind(c, &f) {
+		return
+	}
+	if f.Device != "" {
+		if _, err := db.GetDevice(f.Device); errors.Is(err, mydb.ErrNoResult) {
+			v2Abort(c, http.StatusBadRequest, codeUnknownDevice, "unknown device: "+f.Device)
+			return
+		} else if v2CheckErr(c, err) {
+			return
+		}
+	}
+	tm, err := db.NewTxtMsg(f.Msg)
+	if v2CheckErr(c, err) {
+		return
+	}
+	tm.Device = f.Device
+	if v2CheckErr(c, db.InsertTxtMsg(tm)) {
+		return
+	}
+	tm.Index = 1 // 新消息总是最新的暂存消息
+	c.JSON(http.StatusCreated, tm)
+}
+
+func v2GetMessage(c *gin.Context) {
+	tm, err := db.GetByID(c.Param("id"))
+	if v2CheckErr(c, err) {
+		return
+	}
+	c.JSON(OK, tm)
+}
+
+// v2UpdateMessage 修改消息内容、别名或类型，只修改请求中出现的字段。
+// 注意，修改类型 (cat) 后消息的 ID 会改变。
+func v2UpdateMessage(c *gin.Context) {
+	type form struct {
+		Msg   *string         `json:"msg"`
+		Alias *string         `json:"alias"`
+		Cat   *model.Category `json:"cat"`
+	}
+	var f form
+	if err := c.ShouldBindJSON(&f); err != nil {
+		v2Abort(c, http.StatusBadRequest, codeBadRequest, err.Error())
+		return
+	}
+	if f.Cat != nil && *f.Cat != model.CatTemp && *f.Cat != model.CatPerm {
+		v2Abort(c, http.StatusBadRequest, codeBadRequest, "unknown cat: "+string(*f.Cat))
+		return
+	}
+	if f.Msg != nil && *f.Msg == "" {
+		v2Abort(c, http.StatusBadRequest, codeBadRequest, "msg is empty")
+		return
+	}
+	if f.Msg != nil && len(*f.Msg) > db.GetConfig().MsgSizeLimit {
+		v2CheckErr(c, mydb.ErrMsgTooLong)
+		return
+	}
+
+	tm, err := db.GetByID(c.Param("id"))
+	if v2CheckErr(c, err) {
+		return
+	}
+	if f.Msg != nil || f.Alias != nil {
+		form := model.EditForm{ID: tm.ID, Alias: tm.Alias, Msg: tm.Msg}
+		if f.Msg != nil {
+			form.Msg = *f.Msg
+		}
+		if f.Alias != nil {
+			form.Alias = *f.Alias
+		}
+		if v2CheckErr(c, db.Edit(form)) {
+			return
+		}
+		if tm, err = db.GetByID(tm.ID); v2CheckErr(c, err) {
+			return
+		}
+	}
+	if f.Cat != nil && *f.Cat != tm.Cat {
+		after, err := db.ToggleCat(tm)
+		if v2CheckErr(c, err) {
+			return
+		}
+		if tm, err = db.GetByID(after.ID); v2CheckErr(c, err) {
+			return
+		}
+	}
+	c.JSON(OK, tm)
+}
+
+func v2DeleteMessage(c *gin.Context) {
+	if v2CheckErr(c, db.DeleteTxtMsg(c.Param("id"))) {
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+func v2ListAliases(c *gin.Context) {
+	aliases, err := db.GetAllAliases()
+	if v2CheckErr(c, err) {
+		return
+	}
+	if aliases == nil {
+		aliases = []model.Alias{}
+	}
+	c.JSON(OK, aliases)
+}
+
+// v2GetAlias 返回别名所指向的消息。
+func v2GetAlias(c *gin.Context) {
+	name := c.Param("name")
+	tm, err := db.GetByAliasIndex(name)
+	// GetByAliasIndex 也接受 index (例如 "t1"), 因此要确认找到的消息确实使用该别名。
+	if errors.Is(err, mydb.ErrNoResult) || (err == nil && tm.Alias != name) {
+		v2Abort(c, http.StatusNotFound, codeNotFound, "alias not found: "+name)
+		return
+	}
+	if v2CheckErr(c, err) {
+		return
+	}
+	c.JSON(OK, tm)
+}
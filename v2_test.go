@@ -0,0 +1,148 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// v2Do 发送一个 /v2 请求 (body 是 JSON), 返回状态码与错误代码 (没有错误时为空)。
+func v2Do(t *testing.T, method, url, key, body string) (status int, code string) {
+	t.Helper()
+	var r io.Reader
+	if body != "" {
+		r = strings.NewReader(body)
+	}
+	req, err := http.NewRequest(method, url, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+key)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var e APIError
+	_ = json.NewDecoder(resp.Body).Decode(&e)
+	return resp.StatusCode, e.Code
+}
+
+func TestV2MsgTooLong(t *testing.T) {
+	srv, key := newTestServer(t)
+	long := strings.Repeat("a", db.GetConfig().MsgSizeLimit+1)
+	status, code := v2Do(t, "POST", srv.URL+"/v2/messages", key, `{"msg": "`+long+`"}`)
+	if status != http.StatusRequestEntityTooLarge || code != codeMsgTooLong {
+		t.Errorf("POST a long message: %d %s; want 413 %s", status, code, codeMsgTooLong)
+	}
+}
+
+func TestV2UpdateMessageErrors(t *testing.T) {
+	srv, key := newTestServer(t)
+	tm, err := db.NewTxtMsg("hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.InsertTxtMsg(tm); err != nil {
+		t.Fatal(err)
+	}
+	url := srv.URL + "/v2/messages/" + tm.ID
+	tests := []struct {
+		body   string
+		status int
+		code   string
+	}{
+		{`{"alias": "t1"}`, http.StatusBadRequest, codeBadRequest},
+		{`{"alias": "P12"}`, http.StatusBadRequest, codeBadRequest},
+		{`{"msg": ""}`, http.StatusBadRequest, codeBadRequest},
+		{`{"cat": "x"}`, http.StatusBadRequest, codeBadRequest},
+		{`{"alias": "ok"}`, http.StatusOK, ""},
+	}
+	for _, tt := range tests {
+		status, code := v2Do(t, "PATCH", url, key, tt.body)
+		if status != tt.status || code != tt.code {
+			t.Errorf("PATCH %s: %d %q; want %d %q", tt.body, status, code, tt.status, tt.code)
+		}
+	}
+	if status, code := v2Do(t, "PATCH", srv.URL+"/v2/messages/none", key, `{"msg": "x"}`); status != 404 || code != codeNotFound {
+		t.Errorf("PATCH unknown id: %d %s; want 404 %s", status, code, codeNotFound)
+	}
+}
+
+func TestV2GetAlias(t *testing.T) {
+	srv, key := newTestServer(t)
+	insertTestMsgs(t, 2)
+	if err := db.UpdateAlias("t1", "email"); err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		name   string
+		status int
+		code   string
+	}{
+		{"email", http.StatusOK, ""},
+		{"nope", http.StatusNotFound, codeNotFound},
+		{"t2", http.StatusNotFound, codeNotFound}, // index 不是别名
+		{"t1", http.StatusNotFound, codeNotFound},
+	} {
+		if status, code := v2Do(t, "GET", srv.URL+"/v2/aliases/"+tc.name, key, ""); status != tc.status || code != tc.code {
+			t.Errorf("GET /v2/aliases/%s: %d %q; want %d %q", tc.name, status, code, tc.status, tc.code)
+		}
+	}
+}
+
+func TestV2MiddlewareErrors(t *testing.T) {
+	srv, key := newTestServer(t)
+	url := srv.URL + "/v2/messages"
+
+	if status, code := v2Do(t, "GET", url, "wrong", ""); status != 401 || code != codeWrongKey {
+		t.Errorf("wrong key: %d %s; want 401 %s", status, code, codeWrongKey)
+	}
+
+	*readonly = true
+	status, code := v2Do(t, "POST", url, key, `{"msg": "hello"}`)
+	*readonly = false
+	if status != http.StatusForbidden || code != codeReadOnly {
+		t.Errorf("read-only: %d %s; want 403 %s", status, code, codeReadOnly)
+	}
+
+	*demo = true
+	defer func() { *demo = false }()
+	oldMax, oldRate := *demoMaxMsg, *demoRate
+	defer func() { *demoMaxMsg, *demoRate = oldMax, oldRate }()
+	demoCounter.reset()
+	rateCounter.reset()
+
+	*demoMaxMsg = 0
+	if status, code := v2Do(t, "POST", url, key, `{"msg": "hello"}`); status != 429 || code != codeMsgLimit {
+		t.Errorf("demo message limit: %d %s; want 429 %s", status, code, codeMsgLimit)
+	}
+	*demoRate = 0
+	if status, code := v2Do(t, "GET", url, key, ""); status != 429 || code != codeRateLimited {
+		t.Errorf("demo rate limit: %d %s; want 429 %s", status, code, codeRateLimited)
+	}
+}
+
+// 没有密钥的请求不计入密码错误次数。
+func TestMissingKey(t *testing.T) {
+	srv, key := newTestServer(t)
+	for i := 0; i <= *passwordMaxTry; i++ {
+		for _, url := range []string{"/cli/get-all-aliases", "/v2/aliases"} {
+			if status, code := v2Do(t, "GET", srv.URL+url, "", ""); status != 401 || code != codeKeyRequired {
+				t.Fatalf("%s without a key: %d %s; want 401 %s", url, status, code, codeKeyRequired)
+			}
+		}
+	}
+	if n := ipTryCount.get("127.0.0.1") + ipTryCount.get("all"); n > 0 {
+		t.Errorf("got %d tries; want none", n)
+	}
+	if status, _ := v2Do(t, "GET", srv.URL+"/v2/aliases", key, ""); status != OK {
+		t.Errorf("/v2/aliases with the key: %d; want 200", status)
+	}
+	if status, code := v2Do(t, "GET", srv.URL+"/v2/aliases", "wrong", ""); status != 401 || code != codeWrongKey {
+		t.Errorf("/v2/aliases with a wrong key: %d %s; want 401 %s", status, code, codeWrongKey)
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/ahui2016/txt/model"
+	"github.com/ahui2016/txt/mydb"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultWaitTimeout = 30  // 秒
+	maxWaitTimeout     = 300 // 秒
+)
+
+// nextTempMsg 返回 ID 大于 after 的最早的一条暂存消息。
+func nextTempMsg(after string) (tm model.TxtMsg, found bool, err error) {
+	// 从新到旧排列
+	items, err := db.GetMoreItems(mydb.TempBucket, "", db.GetConfig().TempLimit)
+	if err != nil {
+		return
+	}
+	for _, item := range items {
+		if item.ID <= after {
+			break
+		}
+		tm, found = item, true
+	}
+	return
+}
+
+// isNewTempMsg 判断 ch 是否产生了一条 ID 大于 after 的暂存消息。
+func isNewTempMsg(ch model.Change, after string) bool {
+	if ch.Op != model.OpInsert && ch.Op != model.OpToggle {
+		return false
+	}
+	return ch.Msg.Cat == model.CatTemp && ch.ID > after
+}
+
+// cliWaitHandler 一直等待，直到出现一条 ID 大于 after 的暂存消息 (立即返回该消息)，
+// 或者超时 (返回 204 No Content)。after 为空时表示等待下一条新消息。
+// 等待期间不占用数据库事务，客户端断开连接时立即结束等待。
+func cliWaitHandler(c *gin.Context) {
+	type form struct {
+		After   string `form:"after"`
+		Timeout int    `form:"timeout"` // 秒
+	}
+	var f form
+	if BindCheck(c, &f) {
+		return
+	}
+	if f.Timeout <= 0 {
+		f.Timeout = defaultWaitTimeout
+	}
+	if f.Timeout > maxWaitTimeout {
+		f.Timeout = maxWaitTimeout
+	}
+	if f.After == "" {
+		// 等待下一条新消息，即 ID 大于当前最新暂存消息的消息。
+		latest, err := db.GetMoreItems(mydb.TempBucket, "", 1)
+		if checkErr(c, err) {
+			return
+		}
+		if len(latest) > 0 {
+			f.After = latest[0].ID
+		}
+	}
+	timeout := time.NewTimer(time.Duration(f.Timeout) * time.Second)
+	defer timeout.Stop()
+
+	for {
+		// 必须先订阅再查询，以免遗漏两者之间插入的消息。
+		changes, cancel := db.Subscribe()
+		tm, found, err := nextTempMsg(f.After)
+		if err != nil || found {
+			cancel()
+			if checkErr(c, err) {
+				return
+			}
+			c.JSON(OK, tm)
+			return
+		}
+		tm, found, done := waitTempMsg(c, changes, timeout, f.After)
+		cancel()
+		if found {
+			c.JSON(OK, tm)
+			return
+		}
+		if done {
+			return
+		}
+		// 订阅被关闭 (积压太多), 重新订阅并查询。
+	}
+}
+
+// waitTempMsg 等待一条 ID 大于 after 的暂存消息，如果超时或客户端断开则 done 为 true.
+func waitTempMsg(c *gin.Context, changes <-chan model.Change, timeout *time.Timer, after string) (
+	tm model.TxtMsg, found, done bool,
+) {
+	for {
+		select {
+		case <-c.Request.Context().Done():
+			return tm, false, true // 客户端已断开
+		case <-streams.Done():
+			// 服务器正在关闭，让客户端知道原因。
+			c.Status(http.StatusServiceUnavailable)
+			return tm, false, true
+		case <-timeout.C:
+			c.Status(http.StatusNoContent)
+			return tm, false, true
+		case ch, ok := <-changes:
+			if !ok {
+				return tm, false, false
+			}
+			if !isNewTempMsg(ch, after) {
+				continue
+			}
+			// 重新读取以获得最新的流水号，如果已被删除则使用修改记录中的内容。
+			if latest, err := db.GetByID(ch.ID); err == nil {
+				return latest, true, true
+			}
+			return ch.Msg, true, true
+		}
+	}
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/ahui2016/txt/model"
+	"github.com/ahui2016/txt/mydb"
+	"github.com/ahui2016/txt/util"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	webhookInterval    = 5 * time.Second  // 每隔多久检查一次需要重试的 Delivery
+	webhookTimeout     = 10 * time.Second // 每次发送的超时时间
+	webhookBatch       = 20
+	webhookMaxAttempts = 8
+	webhookMaxBackoff  = time.Hour
+	deliveriesLimit    = 50
+)
+
+var webhookClient = &http.Client{Timeout: webhookTimeout}
+
+// WebhookPayload 是发送给 Webhook 的内容 (JSON)。
+type WebhookPayload struct {
+	Event    model.ChangeOp `json:"event"`
+	Delivery uint64         `json:"delivery"`
+	Msg      model.TxtMsg   `json:"msg"`
+	OldID    string         `json:"old_id,omitempty"` // 仅用于 toggle, 转换前的 ID
+	Time     int64          `json:"time"`
+}
+
+// webhookSignature 返回 body 的 HMAC-SHA256 签名，接收方应使用同样的 secret 验证。
+func webhookSignature(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// backoff 返回第 attempts 次失败后应等待多久再重试。
+func backoff(attempts int) time.Duration {
+	d := 10 * time.Second
+	for i := 1; i < attempts && d < webhookMaxBackoff; i++ {
+		d *= 2
+	}
+	if d > webhookMaxBackoff {
+		d = webhookMaxBackoff
+	}
+	return d
+}
+
+// deliver 发送一次通知并返回更新后的 Delivery.
+func deliver(ctx context.Context, hook model.Webhook, d model.Delivery) model.Delivery {
+	body, err := json.Marshal(WebhookPayload{
+		Event:    d.Change.Op,
+		Delivery: d.ID,
+		Msg:      d.Change.Msg,
+		OldID:    d.Change.OldID,
+		Time:     d.Change.Time,
+	})
+	if err == nil {
+		d.LastCode, err = postWebhook(ctx, hook, d, body)
+	}
+	d.Attempts++
+	d.Updated = util.TimeNow()
+	switch {
+	case err == nil:
+		d.Status = model.DeliveryOK
+		d.LastError = ""
+	case d.Attempts >= webhookMaxAttempts:
+		d.Status = model.DeliveryFailed
+		d.LastError = err.Error()
+	default:
+		d.NextTry = d.Updated + int64(backoff(d.Attempts).Seconds())
+		d.LastError = err.Error()
+	}
+	return d
+}
+
+func postWebhook(ctx context.Context, hook model.Webhook, d model.Delivery, body []byte) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, hook.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "txt-webhook")
+	req.Header.Set("X-Txt-Event", string(d.Change.Op))
+	req.Header.Set("X-Txt-Delivery", strconv.FormatUint(d.ID, 10))
+	req.Header.Set("X-Txt-Signature", webhookSignature(hook.Secret, body))
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("%s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// deliverDue 发送全部到了发送时间的 Delivery.
+func deliverDue(ctx context.Context) error {
+	for {
+		due, err := db.DueDeliveries(util.TimeNow(), webhookBatch)
+		if err != nil || len(due) == 0 {
+			return err
+		}
+		hooks, err := db.GetWebhooks()
+		if err != nil {
+			return err
+		}
+		byID := make(map[string]model.Webhook)
+		for _, hook := range hooks {
+			byID[hook.ID] = hook
+		}
+		for _, d := range due {
+			if ctx.Err() != nil {
+				return nil
+			}
+			var err error
+			if hook, ok := byID[d.HookID]; ok {
+				err = db.UpdateDelivery(deliver(ctx, hook, d))
+			} else {
+				// Webhook 已被删除，必须删除这个 Delivery, 否则每次都会再次取出 (死循环)。
+				err = db.DeleteDelivery(d.ID)
+			}
+			if err != nil && err != mydb.ErrNoResult {
+				return err
+			}
+		}
+		if len(due) < webhookBatch {
+			return nil
+		}
+	}
+}
+
+// webhookLoop 发送 Webhook 通知：每当有新的修改时立即发送，并定期重试失败的通知。
+// Delivery 保存在数据库中，因此重启后会继续发送。
+func webhookLoop(ctx context.Context) {
+	ticker := time.NewTicker(webhookInterval)
+	defer ticker.Stop()
+	changes, cancel := db.Subscribe()
+	defer func() { cancel() }()
+	for {
+		if err := deliverDue(ctx); err != nil {
+			log.Print("[Webhook] ", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case _, ok := <-changes:
+			if !ok {
+				// 积压太多被关闭，重新订阅即可 (Delivery 已保存在数据库中)。
+				changes, cancel = db.Subscribe()
+			}
+		}
+	}
+}
+
+// WebhookCreated 是 "/cli/add-webhook" 的返回结果。
+// Secret 只在添加时返回这一次，"/cli/get-webhooks" 不包含 Secret.
+type WebhookCreated struct {
+	model.Webhook
+	Secret string
+}
+
+func addWebhookHandler(c *gin.Context) {
+	if *demo {
+		c.JSON(http.StatusForbidden, Text{"Demo Mode (演示模式) 不可添加 Webhook."})
+		return
+	}
+	type form struct {
+		URL    string   `form:"url" binding:"required"`
+		Events []string `form:"events"`
+		Secret string   `form:"secret"`
+	}
+	var f form
+	if BindCheck(c, &f) {
+		return
+	}
+	u, err := url.Parse(f.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		c.JSON(http.StatusBadRequest, Text{"invalid url: " + f.URL})
+		return
+	}
+	hook := model.Webhook{
+		ID:      base64.RawURLEncoding.EncodeToString(util.RandomBytes(6)),
+		URL:     f.URL,
+		Secret:  f.Secret,
+		Created: util.TimeNow(),
+	}
+	if hook.Secret == "" {
+		hook.Secret = base64.RawURLEncoding.EncodeToString(util.RandomBytes(24))
+	}
+	for _, event := range f.Events {
+		op := model.ChangeOp(event)
+		switch op {
+		case model.OpInsert, model.OpEdit, model.OpAlias, model.OpToggle, model.OpDelete:
+			hook.Events = append(hook.Events, op)
+		default:
+			c.JSON(http.StatusBadRequest, Text{"unknown event: " + event})
+			return
+		}
+	}
+	if checkErr(c, db.PutWebhook(hook)) {
+		return
+	}
+	c.JSON(OK, WebhookCreated{Webhook: hook, Secret: hook.Secret})
+}
+
+func getWebhooksHandler(c *gin.Context) {
+	hooks, err := db.GetWebhooks()
+	if checkErr(c, err) {
+		return
+	}
+	if hooks == nil {
+		hooks = []model.Webhook{}
+	}
+	c.JSON(OK, hooks)
+}
+
+func deleteWebhookHandler(c *gin.Context) {
+	type form struct {
+		ID string `form:"id" binding:"required"`
+	}
+	var f form
+	if BindCheck(c, &f) {
+		return
+	}
+	checkErr(c, db.DeleteWebhook(f.ID))
+}
+
+// getDeliveriesHandler 返回最近的通知记录 (从新到旧)。
+func getDeliveriesHandler(c *gin.Context) {
+	type form struct {
+		HookID string `form:"hook_id"`
+		Limit  int    `form:"limit"`
+	}
+	var f form
+	if BindCheck(c, &f) {
+		return
+	}
+	if f.Limit <= 0 {
+		f.Limit = deliveriesLimit
+	}
+	deliveries, err := db.GetDeliveries(f.HookID, f.Limit)
+	if checkErr(c, err) {
+		return
+	}
+	if deliveries == nil {
+		deliveries = []model.Delivery{}
+	}
+	c.JSON(OK, deliveries)
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ahui2016/txt/model"
+	"github.com/ahui2016/txt/mydb"
+	"github.com/ahui2016/txt/util"
+)
+
+func TestBackoff(t *testing.T) {
+	for _, tc := range []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{1, 10 * time.Second},
+		{2, 20 * time.Second},
+		{3, 40 * time.Second},
+		{9, 2560 * time.Second},
+		{10, time.Hour},
+		{100, time.Hour},
+	} {
+		if got := backoff(tc.attempts); got != tc.want {
+			t.Errorf("backoff(%d) = %v; want %v", tc.attempts, got, tc.want)
+		}
+	}
+}
+
+func TestDeliver(t *testing.T) {
+	const secret = "hook-secret"
+	status := http.StatusOK
+	var got struct {
+		header  http.Header
+		payload WebhookPayload
+		sigOK   bool
+	}
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		// 接收方的验证方法
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write(body)
+		want := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+		got.sigOK = hmac.Equal([]byte(r.Header.Get("X-Txt-Signature")), []byte(want))
+		got.header = r.Header
+		_ = json.Unmarshal(body, &got.payload)
+		w.WriteHeader(status)
+	}))
+	defer receiver.Close()
+
+	hook := model.Webhook{ID: "h1", URL: receiver.URL, Secret: secret}
+	d := model.Delivery{
+		ID:     7,
+		HookID: hook.ID,
+		Change: model.Change{Op: model.OpInsert, ID: "x", Msg: model.TxtMsg{ID: "x", Msg: "hello"}},
+		Status: model.DeliveryPending,
+	}
+	ctx := context.Background()
+
+	ok := deliver(ctx, hook, d)
+	if ok.Status != model.DeliveryOK || ok.Attempts != 1 || ok.LastCode != OK || ok.LastError != "" {
+		t.Errorf("success: %+v", ok)
+	}
+	if !got.sigOK {
+		t.Error("wrong X-Txt-Signature")
+	}
+	if got.header.Get("X-Txt-Event") != "insert" || got.header.Get("X-Txt-Delivery") != "7" {
+		t.Errorf("headers: %v", got.header)
+	}
+	if got.payload.Event != model.OpInsert || got.payload.Delivery != 7 || got.payload.Msg.Msg != "hello" {
+		t.Errorf("payload: %+v", got.payload)
+	}
+
+	// 失败时按 backoff 安排重试，次数用完后放弃
+	status = http.StatusInternalServerError
+	failed := deliver(ctx, hook, d)
+	if failed.Status != model.DeliveryPending || failed.LastCode != 500 || failed.LastError == "" ||
+		failed.NextTry != failed.Updated+int64(backoff(1).Seconds()) {
+		t.Errorf("first failure: %+v", failed)
+	}
+	d.Attempts = webhookMaxAttempts - 1
+	if last := deliver(ctx, hook, d); last.Status != model.DeliveryFailed || last.Attempts != webhookMaxAttempts {
+		t.Errorf("last failure: %+v", last)
+	}
+}
+
+// Secret 只在添加时返回，列表中不包含 Secret.
+func TestWebhookSecret(t *testing.T) {
+	srv, key := newTestServer(t)
+	resp, err := http.PostForm(srv.URL+"/cli/add-webhook", url.Values{"password": {key}, "url": {"http://127.0.0.1:1/hook"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var created WebhookCreated
+	err = json.NewDecoder(resp.Body).Decode(&created)
+	resp.Body.Close()
+	if err != nil || resp.StatusCode != OK || created.Secret == "" || created.ID == "" {
+		t.Fatalf("add webhook: %d %v %+v", resp.StatusCode, err, created)
+	}
+
+	resp, err = http.PostForm(srv.URL+"/cli/get-webhooks", url.Values{"password": {key}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != OK || !strings.Contains(string(body), created.ID) {
+		t.Fatalf("get webhooks: %d %s", resp.StatusCode, body)
+	}
+	if strings.Contains(string(body), created.Secret) || strings.Contains(string(body), "Secret") {
+		t.Errorf("/cli/get-webhooks returns the secret: %s", body)
+	}
+	// 数据库中仍保存 Secret, 以便签名
+	if hooks, _ := db.GetWebhooks(); len(hooks) != 1 || hooks[0].Secret != created.Secret {
+		t.Errorf("stored webhooks: %+v", hooks)
+	}
+}
+
+// noHooksDB 模拟 Delivery 还在而 Webhook 已不存在的情况。
+type noHooksDB struct{ *mydb.MemDB }
+
+func (noHooksDB) GetWebhooks() ([]model.Webhook, error) { return nil, nil }
+
+func TestDeliverDueOrphans(t *testing.T) {
+	memDB := newTestDB(t)
+	if err := memDB.PutWebhook(model.Webhook{ID: "h1", URL: "http://127.0.0.1:1", Secret: "s"}); err != nil {
+		t.Fatal(err)
+	}
+	insertTestMsgs(t, webhookBatch+5)
+	db = noHooksDB{memDB}
+
+	done := make(chan error, 1)
+	go func() { done <- deliverDue(context.Background()) }()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("deliverDue does not return")
+	}
+	due, err := db.DueDeliveries(util.TimeNow(), 100)
+	if err != nil || len(due) != 0 {
+		t.Errorf("got %d due deliveries, %v; want the orphans deleted", len(due), err)
+	}
+}